@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/slariviere/pdack/pagerduty"
+)
+
+// Actions a rule can take on the incidents it matches
+const (
+	actionAcknowledge = "acknowledge"
+	actionSnooze      = "snooze"
+	actionResolve     = "resolve"
+	actionReassign    = "reassign"
+	actionUrgency     = "urgency"
+	actionNote        = "note"
+)
+
+func (target *Target) resolveIncident(id string) (success bool) {
+	return target.sendPDUpdate("resolve the incident "+id, func(ctx context.Context, from string) error {
+		return target.client.Resolve(ctx, from, id)
+	})
+}
+
+// snoozeIncident acknowledges the incident first, only acknowledged
+// incidents can be snoozed
+func (target *Target) snoozeIncident(id string, duration time.Duration) (success bool) {
+	if !target.acknowledgeIncicent(id) {
+		return false
+	}
+	return target.sendPDUpdate("snooze the incident "+id, func(ctx context.Context, from string) error {
+		return target.client.Snooze(ctx, from, id, duration)
+	})
+}
+
+func (target *Target) reassignIncident(id string, userID string, escalationPolicyID string) (success bool) {
+	return target.sendPDUpdate("reassign the incident "+id, func(ctx context.Context, from string) error {
+		return target.client.Reassign(ctx, from, id, userID, escalationPolicyID)
+	})
+}
+
+func (target *Target) setIncidentUrgency(id string, urgency string) (success bool) {
+	return target.sendPDUpdate("change the urgency of the incident "+id, func(ctx context.Context, from string) error {
+		return target.client.SetUrgency(ctx, from, id, urgency)
+	})
+}
+
+func (target *Target) addIncidentNote(id string, note string) (success bool) {
+	return target.sendPDUpdate("add a note to the incident "+id, func(ctx context.Context, from string) error {
+		return target.client.AddNote(ctx, from, id, note)
+	})
+}
+
+// ruleAction returns the action of the rule, incidents are acknowledged
+// when no rule is configured or when the rule has no action
+func ruleAction(rule *Rule) string {
+	if rule == nil || rule.Action == "" {
+		return actionAcknowledge
+	}
+	return rule.Action
+}
+
+// describeAction returns what the action of the rule does to an incident
+func describeAction(rule *Rule) string {
+	switch ruleAction(rule) {
+	case actionSnooze:
+		return "snoozed for " + rule.snoozeDuration.String()
+	case actionResolve:
+		return "resolved"
+	case actionReassign:
+		return "reassigned to " + rule.AssignUser + rule.AssignEscalationPolicy
+	case actionUrgency:
+		return "moved to " + rule.SetUrgency + " urgency"
+	case actionNote:
+		return "annotated"
+	}
+	return "acknowledged"
+}
+
+// performAction takes the action of the rule on the incident and logs it
+func (target *Target) performAction(rule *Rule, incident Incident) (success bool) {
+	if *dryRun {
+		if rule != nil {
+			target.logf("[dry-run] Incident %s (%s) would be %s, matched by rule %s\n", incident.Title, incident.ID, describeAction(rule), rule.Name)
+		} else {
+			target.logf("[dry-run] Incident %s (%s) would be %s\n", incident.Title, incident.ID, describeAction(rule))
+		}
+		return true
+	}
+	event := target.newAuditEvent(auditAction, incident, rule)
+	event.Action = ruleAction(rule)
+	// The user is looked up before the action is audited, its request is
+	// not an attempt of the action
+	if _, found := target.getPDUserEmail(); !found {
+		return target.finishAction(rule, incident, event, false)
+	}
+	target.auditing = &event
+	switch ruleAction(rule) {
+	case actionSnooze:
+		success = target.snoozeIncident(incident.ID, rule.snoozeDuration)
+	case actionResolve:
+		success = target.resolveIncident(incident.ID)
+	case actionReassign:
+		success = target.reassignIncident(incident.ID, rule.AssignUser, rule.AssignEscalationPolicy)
+	case actionUrgency:
+		success = target.setIncidentUrgency(incident.ID, rule.SetUrgency)
+	case actionNote:
+		success = target.addIncidentNote(incident.ID, rule.Note)
+	default:
+		success = target.acknowledgeIncicent(incident.ID)
+	}
+	target.auditing = nil
+	return target.finishAction(rule, incident, event, success)
+}
+
+// finishAction audits, counts and logs the outcome of the action of the rule
+// on the incident
+func (target *Target) finishAction(rule *Rule, incident Incident, event AuditEvent, success bool) bool {
+	event.Outcome = "success"
+	if !success {
+		event.Outcome = "failure"
+	}
+	target.writeAudit(event)
+	metrics.add("pdack_actions_total", metricLabels("target", target.metricLabel(), "action", event.Action, "outcome", event.Outcome), 1)
+	state.recordAction(incident, rule, success)
+	target.requeue(incident, success)
+	if !success {
+		target.logf("The %s action failed on incident %s (%s)\n", ruleAction(rule), incident.Title, incident.ID)
+		return false
+	}
+	done := describeAction(rule)
+	done = strings.ToUpper(done[:1]) + done[1:]
+	if rule != nil {
+		target.logf("Incident %s (%s) has been %s, matched by rule %s\n", incident.Title, incident.ID, done, rule.Name)
+	} else {
+		target.logf("Incident %s (%s) has been %s\n", incident.Title, incident.ID, done)
+	}
+	return true
+}
+
+// matchedIncident is a triggered incident with the rule matching it
+type matchedIncident struct {
+	rule     *Rule
+	incident Incident
+}
+
+// ackBatchSize is the number of incidents acknowledged per request
+var ackBatchSize = pagerduty.MaxBulkUpdate
+
+// acknowledgeIncidents acknowledges the incidents in bulk, ackBatchSize at a
+// time, and returns how many of them could not be acknowledged
+func (target *Target) acknowledgeIncidents(matched []matchedIncident) (nbFailed int) {
+	for start := 0; start < len(matched); start += ackBatchSize {
+		end := start + ackBatchSize
+		if end > len(matched) {
+			end = len(matched)
+		}
+		nbFailed += target.acknowledgeBatch(matched[start:end])
+	}
+	return nbFailed
+}
+
+// acknowledgeBatch acknowledges the incidents in one request. When PagerDuty
+// refuses it as a whole, the incidents are acknowledged one by one so one
+// bad incident does not block the others
+func (target *Target) acknowledgeBatch(batch []matchedIncident) (nbFailed int) {
+	if len(batch) == 1 {
+		if !target.performAction(batch[0].rule, batch[0].incident) {
+			return 1
+		}
+		return 0
+	}
+	var ids []string
+	for _, matched := range batch {
+		ids = append(ids, matched.incident.ID)
+	}
+	bulk := AuditEvent{Event: auditAction, Target: target.Name, Action: actionAcknowledge, Reason: fmt.Sprintf("bulk acknowledgement of %d incidents", len(batch))}
+	var results map[string]error
+	var lastErr error
+	_, success := target.getPDUserEmail()
+	if success {
+		target.auditing = &bulk
+		success = target.sendPDUpdate(fmt.Sprintf("acknowledge %d incidents", len(batch)), func(ctx context.Context, from string) (err error) {
+			results, err = target.client.AcknowledgeAll(ctx, from, ids)
+			lastErr = err
+			return err
+		})
+		target.auditing = nil
+	}
+
+	// The incidents would be denied one by one as well
+	var apiErr *pagerduty.APIError
+	denied := errors.As(lastErr, &apiErr) && apiErr.StatusCode == http.StatusForbidden
+	if !success && lastErr != nil && !pagerduty.Temporary(lastErr) && target.fatal == 0 && !denied {
+		target.logf("PagerDuty refused to acknowledge %d incidents at once, acknowledging them one by one", len(batch))
+		for _, matched := range batch {
+			if !target.performAction(matched.rule, matched.incident) {
+				nbFailed++
+			}
+		}
+		return nbFailed
+	}
+	for _, matched := range batch {
+		event := target.newAuditEvent(auditAction, matched.incident, matched.rule)
+		event.Action = actionAcknowledge
+		event.HTTPStatus, event.Attempt = bulk.HTTPStatus, bulk.Attempt
+		acknowledged := success && results[matched.incident.ID] == nil
+		if success && !acknowledged {
+			target.logf("Unable to acknowledge the incident %s, %s", matched.incident.ID, results[matched.incident.ID])
+		}
+		if !target.finishAction(matched.rule, matched.incident, event, acknowledged) {
+			nbFailed++
+		}
+	}
+	return nbFailed
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/h2non/gock.v0"
+
+	"github.com/slariviere/pdack/pagerduty"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPerformActionAcknowledge(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString(`"status":"acknowledged"`).
+		MatchHeader("From", "sebastien@lariviere.me").
+		Reply(200).
+		BodyString(`{"incidents":[]}`)
+
+	assert.Equal(t, target.performAction(nil, newTestIncident()), true, "Incidents should be acknowledged when no rule is configured")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+func TestPerformActionSnooze(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	rule := &Rule{Name: "snooze", Action: actionSnooze, snoozeDuration: time.Hour}
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString(`"status":"acknowledged"`).
+		Reply(200).
+		BodyString(`{"incidents":[]}`)
+
+	gock.New("https://api.pagerduty.com").
+		Post("/incidents/PO7FKW9/snooze").
+		BodyString(`{"duration":3600}`).
+		MatchHeader("From", "sebastien@lariviere.me").
+		Reply(201).
+		BodyString(`{"incident":{}}`)
+
+	assert.Equal(t, target.performAction(rule, newTestIncident()), true, "The incident should be acknowledged then snoozed")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+func TestPerformActionResolve(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	rule := &Rule{Name: "resolve", Action: actionResolve}
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString(`"status":"resolved"`).
+		Reply(200).
+		BodyString(`{"incidents":[]}`)
+
+	assert.Equal(t, target.performAction(rule, newTestIncident()), true, "The incident should be resolved")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+func TestPerformActionReassign(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents/PO7FKW9").
+		BodyString(`{"incident":{"assignments":[{"assignee":{"id":"PXPGF42","type":"user_reference"}}],"type":"incident_reference"}}`).
+		Reply(200).
+		BodyString(`{"incident":{}}`)
+
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents/PO7FKW9").
+		BodyString(`{"incident":{"escalation_policy":{"id":"PT20YPA","type":"escalation_policy_reference"},"type":"incident_reference"}}`).
+		Reply(200).
+		BodyString(`{"incident":{}}`)
+
+	assert.Equal(t, target.performAction(&Rule{Name: "user", Action: actionReassign, AssignUser: "PXPGF42"}, newTestIncident()), true, "The incident should be reassigned to the user")
+	assert.Equal(t, target.performAction(&Rule{Name: "policy", Action: actionReassign, AssignEscalationPolicy: "PT20YPA"}, newTestIncident()), true, "The incident should be reassigned to the escalation policy")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+func TestPerformActionUrgency(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	rule := &Rule{Name: "urgency", Action: actionUrgency, SetUrgency: "low"}
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents/PO7FKW9").
+		BodyString(`{"incident":{"type":"incident_reference","urgency":"low"}}`).
+		Reply(200).
+		BodyString(`{"incident":{}}`)
+
+	assert.Equal(t, target.performAction(rule, newTestIncident()), true, "The urgency of the incident should be changed")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+func TestPerformActionNote(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	rule := &Rule{Name: "note", Action: actionNote, Note: `Known "flapping" check`}
+	gock.New("https://api.pagerduty.com").
+		Post("/incidents/PO7FKW9/notes").
+		BodyString(`{"note":{"content":"Known \"flapping\" check"}}`).
+		Reply(201).
+		BodyString(`{"note":{}}`)
+
+	b.Reset()
+	assert.Equal(t, target.performAction(rule, newTestIncident()), true, "A note should be added to the incident")
+	traceBuffer.Flush()
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+	assert.True(t, strings.Contains(b.String(), "has been Annotated, matched by rule note"), "Expected the action in the logs: %s", b.String())
+}
+
+func TestPerformActionRetriesFails(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	rule := &Rule{Name: "resolve", Action: actionResolve}
+	for i := 0; i <= defaultMaxRetries; i++ {
+		gock.New("https://api.pagerduty.com").
+			Put("/incidents").
+			Reply(500).
+			BodyString(`{"error":{"message":"Internal Server Error"}}`)
+	}
+
+	assert.Equal(t, target.performAction(rule, newTestIncident()), false, "Response code is 500 too many times, performAction should return false")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+// stubClient answers the calls of a target without any HTTP request, the
+// calls it does not override panic
+type stubClient struct {
+	pagerduty.API
+	acknowledged []string
+	from         string
+	err          error
+}
+
+func (stub *stubClient) Acknowledge(ctx context.Context, from string, id string) error {
+	stub.acknowledged = append(stub.acknowledged, id)
+	stub.from = from
+	return stub.err
+}
+
+func TestPerformActionStubbed(t *testing.T) {
+	target := newTestTarget()
+	stub := &stubClient{}
+	target.client = stub
+	assert.True(t, target.performAction(nil, newTestIncident()), "The incident should be acknowledged")
+	assert.Equal(t, stub.acknowledged, []string{"PO7FKW9"}, "The incident should be acknowledged through the client")
+	assert.Equal(t, stub.from, "sebastien@lariviere.me", "The incident should be acknowledged on behalf of the user")
+
+	stub.err = &pagerduty.APIError{StatusCode: 500}
+	assert.False(t, target.performAction(nil, newTestIncident()), "The acknowledgement should fail")
+	assert.Equal(t, len(stub.acknowledged), 2+defaultMaxRetries, "Temporary errors should be retried")
+
+	stub.err = &pagerduty.APIError{StatusCode: 404}
+	stub.acknowledged = nil
+	assert.False(t, target.performAction(nil, newTestIncident()), "The acknowledgement should fail")
+	assert.Equal(t, len(stub.acknowledged), 1, "Other errors should not be retried")
+}
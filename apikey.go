@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// apiKeyCommandTimeout is how long apiKeyCommand may run, a password
+// manager waiting for a passphrase nobody types would block pdack otherwise
+var apiKeyCommandTimeout = 30 * time.Second
+
+// resolveAPIKey reads the API key from apiKeyFile or from the output of
+// apiKeyCommand when one of them is set instead of apiKey
+func (pdConfig *PagerDutyConfig) resolveAPIKey() (err error) {
+	sources := 0
+	for _, set := range []bool{pdConfig.APIKey != "", pdConfig.APIKeyFile != "", pdConfig.APIKeyCommand != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return errors.New("only one of apiKey, apiKeyFile and apiKeyCommand can be set")
+	}
+	switch {
+	case pdConfig.APIKeyFile != "":
+		content, err := ioutil.ReadFile(pdConfig.APIKeyFile)
+		if err != nil {
+			return fmt.Errorf("unable to read apiKeyFile: %s", err)
+		}
+		pdConfig.APIKey = strings.TrimSpace(string(content))
+	case pdConfig.APIKeyCommand != "":
+		if pdConfig.APIKey, err = runAPIKeyCommand(pdConfig.APIKeyCommand); err != nil {
+			return fmt.Errorf("unable to run apiKeyCommand: %s", err)
+		}
+	default:
+		return nil
+	}
+	if pdConfig.APIKey == "" {
+		return errors.New("the API key read from apiKeyFile or apiKeyCommand is empty")
+	}
+	return nil
+}
+
+// runAPIKeyCommand runs the command with the shell and returns the first
+// line it printed, pass show prints the password first
+func runAPIKeyCommand(command string) (apiKey string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiKeyCommandTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%s: %s", err, message)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(strings.SplitN(stdout.String(), "\n", 2)[0]), nil
+}
+
+// keepOverriddenAPIKey drops the API key sources of the file when the key
+// is given through the environment or a flag, however the file gives it
+func (pdConfig *PagerDutyConfig) keepOverriddenAPIKey(overridden map[string]bool) {
+	if !overridden["apiKey"] && !overridden["apiKeyFile"] && !overridden["apiKeyCommand"] {
+		return
+	}
+	if !overridden["apiKey"] {
+		pdConfig.APIKey = ""
+	}
+	if !overridden["apiKeyFile"] {
+		pdConfig.APIKeyFile = ""
+	}
+	if !overridden["apiKeyCommand"] {
+		pdConfig.APIKeyCommand = ""
+	}
+}
+
+// checkConfigPermissions refuses a configuration file holding an API key
+// when anybody but its owner can read it
+func checkConfigPermissions(configFileName string, md toml.MetaData) (success bool) {
+	inlineKey := false
+	for _, key := range md.Keys() {
+		inlineKey = inlineKey || strings.EqualFold(key[len(key)-1], "apiKey")
+	}
+	if !inlineKey {
+		return true
+	}
+	info, err := os.Stat(configFileName)
+	if err != nil {
+		log.Printf("An error occured while reading the configuation file: %s", err)
+		return false
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		log.Printf("The configuation file %s holds an API key and can be read by group or others, run chmod 600 %s or use apiKeyFile or apiKeyCommand", configFileName, configFileName)
+		return false
+	}
+	return true
+}
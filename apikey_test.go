@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveAPIKeyFile(t *testing.T) {
+	directory, _ := ioutil.TempDir("", "pdack")
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "api_key")
+	ioutil.WriteFile(path, []byte("from-file\n"), 0600)
+
+	pdConfig := PagerDutyConfig{APIKeyFile: path}
+	assert.Nil(t, pdConfig.resolveAPIKey(), "The key file should be read")
+	assert.Equal(t, pdConfig.APIKey, "from-file", "The key should be trimmed")
+
+	pdConfig = PagerDutyConfig{APIKeyFile: filepath.Join(directory, "missing")}
+	assert.NotNil(t, pdConfig.resolveAPIKey(), "A missing key file should be an error")
+
+	ioutil.WriteFile(path, []byte("\n"), 0600)
+	pdConfig = PagerDutyConfig{APIKeyFile: path}
+	assert.NotNil(t, pdConfig.resolveAPIKey(), "An empty key should be an error")
+}
+
+func TestResolveAPIKeyCommand(t *testing.T) {
+	pdConfig := PagerDutyConfig{APIKeyCommand: `printf 'from-command\nlogin: pdack\n'`}
+	assert.Nil(t, pdConfig.resolveAPIKey(), "The command should be run")
+	assert.Equal(t, pdConfig.APIKey, "from-command", "Only the first line should be kept")
+
+	pdConfig = PagerDutyConfig{APIKeyCommand: "echo 'Error: pagerduty is not in the password store' >&2; exit 1"}
+	err := pdConfig.resolveAPIKey()
+	assert.NotNil(t, err, "A failing command should be an error")
+	assert.Contains(t, err.Error(), "not in the password store", "The error of the command should be reported")
+
+	pdConfig = PagerDutyConfig{APIKey: "123", APIKeyCommand: "echo 456"}
+	assert.NotNil(t, pdConfig.resolveAPIKey(), "Only one source should be allowed")
+}
+
+func TestAPIKeyOverride(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	defer os.Unsetenv("PDACK_API_KEY_COMMAND")
+	os.Setenv("PDACK_API_KEY_COMMAND", "echo from-env")
+	pwd, _ := os.Getwd()
+
+	_, res := readConfigFile(pwd + "/pdack_sample.conf")
+	assert.True(t, res, "The key of the environment should replace the one of the file")
+	assert.Equal(t, config.APIKey, "from-env", "Invalid API key")
+}
+
+func TestCheckConfigPermissions(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	directory, _ := ioutil.TempDir("", "pdack")
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "pdack.conf")
+	ioutil.WriteFile(path, []byte("apiKey=\"123\"\nuserID=\"XXXXXXX\"\naccount=\"your_account\"\nrefreshDelay=60\n"), 0644)
+
+	md, _ := readConfigFile(path)
+	assert.False(t, checkConfigPermissions(path, md), "An inline key readable by others should be refused")
+	os.Chmod(path, 0600)
+	assert.True(t, checkConfigPermissions(path, md), "An inline key only readable by its owner should be accepted")
+
+	ioutil.WriteFile(path, []byte("apiKeyCommand=\"echo 123\"\nuserID=\"XXXXXXX\"\naccount=\"your_account\"\nrefreshDelay=60\n"), 0644)
+	os.Chmod(path, 0644)
+	md, _ = readConfigFile(path)
+	assert.True(t, checkConfigPermissions(path, md), "A file without key can be readable by others")
+	assert.Equal(t, config.APIKey, "123", "The key should be read from the command")
+}
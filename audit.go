@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audit events
+const (
+	auditAction = "action"
+	auditRetry  = "retry"
+	auditSkip   = "skip"
+)
+
+// AuditEvent is a line of the audit log, recording something pdack did, or
+// decided not to do, on behalf of the user
+type AuditEvent struct {
+	Time           time.Time `json:"time"`
+	Event          string    `json:"event"`
+	Target         string    `json:"target,omitempty"`
+	Action         string    `json:"action,omitempty"`
+	Outcome        string    `json:"outcome,omitempty"`
+	Reason         string    `json:"reason,omitempty"`
+	IncidentID     string    `json:"incident_id"`
+	IncidentNumber int       `json:"incident_number"`
+	Service        string    `json:"service"`
+	Subject        string    `json:"subject"`
+	Rule           string    `json:"rule,omitempty"`
+	HTTPStatus     int       `json:"http_status,omitempty"`
+	Attempt        int       `json:"attempt,omitempty"`
+}
+
+// AuditLog appends the audit events to a file as JSON lines, rotating it
+// once it grows over maxSize bytes and keeping maxBackups rotated files
+type AuditLog struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	mutex      sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+// audit is the audit log of the process, nil when no auditFile is configured
+var audit *AuditLog
+
+var defaultAuditMaxSize = 100
+var defaultAuditMaxBackups = 5
+
+func openAuditLog(path string, maxSize int64, maxBackups int) (auditLog *AuditLog, err error) {
+	auditLog = &AuditLog{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := auditLog.open(); err != nil {
+		return nil, err
+	}
+	return auditLog, nil
+}
+
+func (auditLog *AuditLog) open() (err error) {
+	auditLog.file, err = os.OpenFile(auditLog.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := auditLog.file.Stat()
+	if err != nil {
+		auditLog.file.Close()
+		return err
+	}
+	auditLog.size = info.Size()
+	return nil
+}
+
+// rotate renames audit.log.1 to audit.log.2 and so on, dropping the oldest
+// one, then renames audit.log to audit.log.1 and starts a new file
+func (auditLog *AuditLog) rotate() (err error) {
+	auditLog.file.Close()
+	os.Remove(fmt.Sprintf("%s.%d", auditLog.path, auditLog.maxBackups))
+	for i := auditLog.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", auditLog.path, i), fmt.Sprintf("%s.%d", auditLog.path, i+1))
+	}
+	if auditLog.maxBackups > 0 {
+		if err := os.Rename(auditLog.path, auditLog.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(auditLog.path); err != nil {
+		return err
+	}
+	return auditLog.open()
+}
+
+// write appends the event to the audit log, synced to disk
+func (auditLog *AuditLog) write(event AuditEvent) (err error) {
+	if auditLog == nil {
+		return nil
+	}
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if auditLog.maxSize > 0 && auditLog.size > 0 && auditLog.size+int64(len(line)) > auditLog.maxSize {
+		if err := auditLog.rotate(); err != nil {
+			return err
+		}
+	}
+	written, err := auditLog.file.Write(line)
+	auditLog.size += int64(written)
+	if err != nil {
+		return err
+	}
+	return auditLog.file.Sync()
+}
+
+func (auditLog *AuditLog) close() {
+	if auditLog == nil {
+		return
+	}
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+	auditLog.file.Close()
+}
+
+// newAuditEvent returns an event about the incident, handled by the rule
+func (target *Target) newAuditEvent(event string, incident Incident, rule *Rule) AuditEvent {
+	auditEvent := AuditEvent{
+		Time:           now(),
+		Event:          event,
+		Target:         target.Name,
+		IncidentID:     incident.ID,
+		IncidentNumber: incident.IncidentNumber,
+		Service:        incident.Service.Summary,
+		Subject:        incident.Title,
+	}
+	if rule != nil {
+		auditEvent.Rule = rule.Name
+	}
+	return auditEvent
+}
+
+// writeAudit appends the event to the audit log, if enabled
+func (target *Target) writeAudit(event AuditEvent) {
+	if err := audit.write(event); err != nil {
+		target.logf("An error occured while writing the audit log: %s", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/h2non/gock.v0"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readAuditEvents(path string) (events []AuditEvent) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event AuditEvent
+		json.Unmarshal(scanner.Bytes(), &event)
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	directory, _ := ioutil.TempDir("", "pdack")
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "audit.log")
+
+	line, _ := json.Marshal(AuditEvent{Event: auditSkip, IncidentID: "PO7FKW9"})
+	// Room for two events per file
+	auditLog, err := openAuditLog(path, int64(2*(len(line)+1)), 2)
+	assert.Nil(t, err, "The audit log should be opened")
+	for i := 0; i < 7; i++ {
+		assert.Nil(t, auditLog.write(AuditEvent{Event: auditSkip, IncidentID: "PO7FKW9"}), "The event should be written")
+	}
+	auditLog.close()
+
+	assert.Equal(t, len(readAuditEvents(path)), 1, "The current file should hold the last event")
+	assert.Equal(t, len(readAuditEvents(path+".1")), 2, "The first backup should be full")
+	assert.Equal(t, len(readAuditEvents(path+".2")), 2, "The second backup should be full")
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "Only maxBackups backups should be kept")
+
+	// Reopening appends to the existing file
+	auditLog, _ = openAuditLog(path, 0, 2)
+	auditLog.write(AuditEvent{Event: auditSkip, IncidentID: "PO7FKW0"})
+	auditLog.close()
+	events := readAuditEvents(path)
+	assert.Equal(t, len(events), 2, "The audit log should be append only")
+	assert.Equal(t, events[1].IncidentID, "PO7FKW0", "Invalid event appended")
+}
+
+func TestPerformActionAudit(t *testing.T) {
+	defer gock.Off()
+	defer func() { audit = nil }()
+	directory, _ := ioutil.TempDir("", "pdack")
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "audit.log")
+	audit, _ = openAuditLog(path, 0, 0)
+
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		Reply(500).
+		BodyString(`{"error":{"message":"Internal Server Error"}}`)
+
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		Reply(200).
+		BodyString(`{"incidents":[]}`)
+
+	rule := &Rule{Name: "flapping-disk", Action: actionResolve}
+	assert.Equal(t, target.performAction(rule, newTestIncident()), true, "The incident should be resolved on the second attempt")
+	audit.close()
+
+	events := readAuditEvents(path)
+	assert.Equal(t, len(events), 2, "Expected a retry and an action event")
+	assert.Equal(t, events[0].Event, auditRetry, "The retry should be audited")
+	assert.Equal(t, events[0].HTTPStatus, 500, "Invalid status for the retry")
+	assert.Equal(t, events[0].Attempt, 1, "Invalid attempt for the retry")
+	assert.Equal(t, events[1].Event, auditAction, "The action should be audited")
+	assert.Equal(t, events[1].Action, actionResolve, "Invalid action")
+	assert.Equal(t, events[1].Outcome, "success", "Invalid outcome")
+	assert.Equal(t, events[1].HTTPStatus, 200, "Invalid status for the action")
+	assert.Equal(t, events[1].Attempt, 2, "Invalid attempt for the action")
+	assert.Equal(t, events[1].IncidentID, "PO7FKW9", "Invalid incident ID")
+	assert.Equal(t, events[1].Service, "TEST_SERVICE", "Invalid service")
+	assert.Equal(t, events[1].Subject, "Disk usage on db01 is WARNING", "Invalid subject")
+	assert.Equal(t, events[1].Rule, "flapping-disk", "Invalid rule")
+}
+
+func TestGetAssignedPDIncidentsAuditSkip(t *testing.T) {
+	defer gock.Off()
+	defer func() { audit = nil }()
+	directory, _ := ioutil.TempDir("", "pdack")
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "audit.log")
+	audit, _ = openAuditLog(path, 0, 0)
+
+	target := newTestTarget()
+	target.Rules = []Rule{{Name: "nothing", Services: []string{"NOTHING"}}}
+	compileRules(target.Rules)
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=" + config.UserID).
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","incident_number":111661,"title":"t","status":"triggered","service":{"id":"P7C31P0","summary":"TEST_SERVICE"}}],"limit":100,"offset":0,"total":null,"more":false}`)
+
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Skipping an incident is not a failure")
+	audit.close()
+
+	events := readAuditEvents(path)
+	assert.Equal(t, len(events), 1, "Expected a skip event")
+	assert.Equal(t, events[0].Event, auditSkip, "The skip should be audited")
+	assert.Equal(t, events[0].Reason, "no rule matched", "Invalid reason")
+	assert.Equal(t, events[0].IncidentNumber, 111661, "Invalid incident number")
+}
+
+func TestPerformActionAuditUserLookup(t *testing.T) {
+	defer gock.Off()
+	defer func() { audit = nil }()
+	directory, _ := ioutil.TempDir("", "pdack")
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "audit.log")
+	audit, _ = openAuditLog(path, 0, 0)
+
+	target := newTestTarget()
+	target.pdUserEmail = ""
+	gock.New("https://api.pagerduty.com/users/" + config.UserID).
+		Reply(200).
+		BodyString(`{"user":{"id":"XXXXXXX","email":"sebastien@lariviere.me"}}`)
+
+	for _, status := range []int{500, 200} {
+		gock.New("https://api.pagerduty.com").
+			Put("/incidents").
+			Reply(status).
+			BodyString(`{"incidents":[]}`)
+	}
+
+	gock.New("https://api.pagerduty.com").
+		Post("/incidents/PO7FKW9/snooze").
+		Reply(201).
+		BodyString(`{"incident":{"id":"PO7FKW9"}}`)
+
+	rule := &Rule{Name: "snooze", Action: actionSnooze, snoozeDuration: time.Hour}
+	assert.Equal(t, target.performAction(rule, newTestIncident()), true, "The incident should be snoozed")
+	audit.close()
+
+	events := readAuditEvents(path)
+	assert.Equal(t, len(events), 2, "Expected a retry and an action event")
+	assert.Equal(t, events[1].HTTPStatus, 201, "Invalid status for the action")
+	assert.Equal(t, events[1].Attempt, 1, "The lookup of the user and the acknowledgement should not be counted as attempts")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
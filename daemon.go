@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Exit codes of pdack, telling its supervisor why it stopped
+const (
+	exitOK             = 0 // Stopped by SIGTERM or SIGINT, or valid configuration
+	exitInvalidConfig  = 1 // Invalid configuration, or unable to start
+	exitUsage          = 2 // Unknown command or flag
+	exitAPIKeyRejected = 3 // PagerDuty rejected the API key of the targets
+	exitAccessDenied   = 4 // PagerDuty denied the API key of the targets access
+)
+
+// signals receives SIGTERM and SIGINT to stop, SIGHUP to reload
+var signals = make(chan os.Signal, 1)
+
+// shutdownTimeout is how long the HTTP requests in flight are waited for
+// when pdack stops
+var shutdownTimeout = 30 * time.Second
+
+// Poller polls every target on its own until they are stopped
+type Poller struct {
+	targets []*Target
+	// stopping is cancelled to stop the targets, the requests in flight
+	// finish but none is sent again
+	stopping context.Context
+	stop     context.CancelFunc
+	// done is closed once every target returned, stopped or failed
+	done chan struct{}
+}
+
+// startPolling polls every target in the background
+func startPolling(targets []*Target) *Poller {
+	poller := &Poller{targets: targets, done: make(chan struct{})}
+	poller.stopping, poller.stop = context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		health.register(target)
+		target.mutex.Lock()
+		target.stopping = poller.stopping
+		target.mutex.Unlock()
+		wg.Add(1)
+		go func(target *Target) {
+			defer wg.Done()
+			target.poll(poller.stopping)
+		}(target)
+	}
+	go func() {
+		wg.Wait()
+		close(poller.done)
+	}()
+	return poller
+}
+
+// exitCode returns the exit code of the unrecoverable errors which stopped
+// the targets, the highest one when they met different errors
+func (poller *Poller) exitCode() (exitCode int) {
+	for _, target := range poller.targets {
+		if fatal := target.getFatal(); fatal > exitCode {
+			exitCode = fatal
+		}
+	}
+	return exitCode
+}
+
+// stopPolling lets the requests in flight finish, then stops every target
+// without waiting for their retries nor their remaining actions
+func (poller *Poller) stopPolling() {
+	poller.stop()
+	<-poller.done
+}
+
+// reload applies the configuration file again without restarting, reading
+// the API keys again. The current configuration is kept when the new one is
+// invalid. The polls and the webhooks in flight finish before the new
+// targets take over
+func reload(poller *Poller, receiver *WebhookReceiver) *Poller {
+	log.Printf("Reloading the configuration file")
+	previous := config
+	config = PagerDutyConfig{}
+	md, success := readConfigFile(getConfigFilePath())
+	success = success && checkConfigPermissions(getConfigFilePath(), md) && checkMode()
+	var newState *StateStore
+	var newAudit *AuditLog
+	if success {
+		newState, newAudit, success = openFiles(previous)
+	}
+	if !success {
+		log.Printf("The configuration file is invalid, keeping the current configuration")
+		config = previous
+		return poller
+	}
+	if config.ListenAddress != previous.ListenAddress || config.getWebhookPath() != previous.getWebhookPath() {
+		log.Printf("listenAddress and webhookPath changes are only applied on restart")
+		config.ListenAddress, config.WebhookPath = previous.ListenAddress, previous.WebhookPath
+	}
+
+	targets := getTargets()
+	poller.stopPolling()
+	receiver.pause()
+	carryOver(poller.targets, targets)
+	if newState != state {
+		if err := state.save(); err != nil {
+			log.Printf("An error occured while writing the state file: %s", err)
+		}
+		state = newState
+	}
+	if newAudit != audit {
+		audit.close()
+		audit = newAudit
+	}
+	health.replace(targets)
+	receiver.resume(config.WebhookSecret, targets)
+	log.Printf("The configuration file has been reloaded")
+	return startPolling(targets)
+}
+
+// openFiles opens the state file and the audit log of the new
+// configuration, the current ones are returned when they did not change
+func openFiles(previous PagerDutyConfig) (newState *StateStore, newAudit *AuditLog, success bool) {
+	newState, newAudit = state, audit
+	var err error
+	if config.StateFile != previous.StateFile {
+		newState = nil
+		if config.StateFile != "" {
+			if newState, err = loadState(config.StateFile); err != nil {
+				log.Printf("An error occured while reading the state file: %s", err)
+				return nil, nil, false
+			}
+		}
+	}
+	if config.AuditFile != previous.AuditFile || config.getAuditMaxSize() != previous.getAuditMaxSize() || config.getAuditMaxBackups() != previous.getAuditMaxBackups() {
+		newAudit = nil
+		if config.AuditFile != "" {
+			if newAudit, err = openAuditLog(config.AuditFile, config.getAuditMaxSize(), config.getAuditMaxBackups()); err != nil {
+				log.Printf("An error occured while opening the audit log: %s", err)
+				return nil, nil, false
+			}
+		}
+	}
+	return newState, newAudit, true
+}
+
+// carryOver keeps what the reloaded targets learnt so far, the occurrences
+// of the incidents, the actions to try again and whether their user is on
+// call
+func carryOver(previous []*Target, targets []*Target) {
+	for _, target := range targets {
+		for _, previousTarget := range previous {
+			if previousTarget.metricLabel() == target.metricLabel() && previousTarget.UserID == target.UserID {
+				target.occurrences = previousTarget.occurrences
+				target.failedActions = previousTarget.failedActions
+				target.idle = previousTarget.idle
+			}
+		}
+	}
+}
+
+// shutdown stops serving the HTTP requests once those in flight are done,
+// waits for the webhooks being handled and flushes the state
+func shutdown(server *http.Server, receiver *WebhookReceiver) {
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("An error occured while stopping the HTTP server: %s", err)
+		}
+	}
+	receiver.pause()
+	if err := state.save(); err != nil {
+		log.Printf("An error occured while writing the state file: %s", err)
+	}
+}
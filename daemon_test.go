@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"gopkg.in/h2non/gock.v0"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestConfig(t *testing.T, path string, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mockNoIncidents() {
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=XXXXXXX").
+		Persist().
+		Reply(200).
+		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":null,"more":false}`)
+}
+
+func TestReload(t *testing.T) {
+	defer gock.Off()
+	defer func(previous PagerDutyConfig, previousFilename string) {
+		config, *filename = previous, previousFilename
+		health = newHealthRegistry()
+	}(config, *filename)
+	path := filepath.Join(t.TempDir(), "pdack.conf")
+	// reload reads the configuration file given with -conf, relative to the
+	// working directory
+	pwd, _ := os.Getwd()
+	*filename, _ = filepath.Rel(pwd, path)
+	mockNoIncidents()
+
+	writeTestConfig(t, path, "apiKey=\"123\"\nuserID=\"XXXXXXX\"\naccount=\"your_account\"\nrefreshDelay=3600\n")
+	_, success := readConfigFile(path)
+	assert.True(t, success, "The configuration should be valid")
+	poller := startPolling(getTargets())
+
+	writeTestConfig(t, path, "apiKey=\"456\"\n")
+	assert.Equal(t, reload(poller, nil), poller, "An invalid configuration should keep the targets")
+	assert.Equal(t, config.APIKey, "123", "An invalid configuration should be rejected")
+
+	writeTestConfig(t, path, "apiKey=\"456\"\nuserID=\"XXXXXXX\"\naccount=\"your_account\"\nrefreshDelay=1800\n")
+	reloaded := reload(poller, nil)
+	assert.NotEqual(t, reloaded, poller, "The targets should be replaced")
+	assert.Equal(t, config.APIKey, "456", "The new configuration should be applied")
+	assert.Equal(t, reloaded.targets[0].RefreshDelay, 1800, "The new targets should use the new configuration")
+	select {
+	case <-poller.done:
+	default:
+		t.Error("The previous targets should be stopped")
+	}
+	reloaded.stopPolling()
+}
+
+func TestMainGracefulShutdown(t *testing.T) {
+	defer gock.Off()
+	defer func(previous PagerDutyConfig) { config = previous }(config)
+	os.Args = []string{os.Args[0], "--conf=" + privateCopy(t, "pdack_sample.conf")}
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=XXXXXXX").
+		Reply(200).
+		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":null,"more":false}`)
+
+	testExitCode = -1
+	signals <- syscall.SIGTERM
+	main()
+	assert.Equal(t, testExitCode, 0, "Program should have exited with code 0")
+	assert.True(t, gock.IsDone(), "The poll in flight should have finished")
+}
+
+func TestStopPollingDuringBackoff(t *testing.T) {
+	defer gock.Off()
+	defer func() { health = newHealthRegistry() }()
+	target := newTestTarget()
+	target.RetryDelay, target.RetryMaxDelay = "1h", "1h"
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=" + config.UserID).
+		Reply(503).
+		BodyString(`{"error":{"message":"Service Unavailable","code":2000}}`)
+
+	poller := startPolling([]*Target{target})
+	for deadline := time.Now().Add(5 * time.Second); !gock.IsDone() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		poller.stopPolling()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The target should stop without waiting for the backoff")
+	}
+	assert.True(t, gock.IsDone(), "The request in flight should have been sent")
+}
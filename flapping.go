@@ -0,0 +1,90 @@
+package main
+
+import (
+	"time"
+)
+
+// occurrence is an incident seen by a target, at the time it was created
+type occurrence struct {
+	id string
+	at time.Time
+}
+
+// flapKey returns the key the occurrences of the incident are tracked with,
+// by incident key unless the rule tracks them by subject
+func flapKey(rule *Rule, incident Incident) string {
+	if rule.FlapBy == "subject" {
+		return "subject:" + incident.Title
+	}
+	return "incidentKey:" + incident.IncidentKey
+}
+
+// recordOccurrence remembers the incident, once, under both its incident key
+// and its subject, unless it is older than any rule cares about
+func (target *Target) recordOccurrence(incident Incident) {
+	if target.flapHorizon() == 0 {
+		return
+	}
+	at, err := time.Parse(time.RFC3339, incident.CreatedAt)
+	if err != nil {
+		at = now()
+	}
+	if !at.After(now().Add(-target.flapHorizon())) {
+		return
+	}
+	for _, key := range []string{"incidentKey:" + incident.IncidentKey, "subject:" + incident.Title} {
+		seen := false
+		for _, previous := range target.occurrences[key] {
+			seen = seen || previous.id == incident.ID
+		}
+		if !seen {
+			target.occurrences[key] = append(target.occurrences[key], occurrence{id: incident.ID, at: at})
+		}
+	}
+}
+
+// pruneOccurrences forgets the occurrences older than any rule cares about,
+// under every key, including those of the incidents no longer seen
+func (target *Target) pruneOccurrences() {
+	horizon := now().Add(-target.flapHorizon())
+	for key, occurrences := range target.occurrences {
+		var kept []occurrence
+		for _, previous := range occurrences {
+			if previous.at.After(horizon) {
+				kept = append(kept, previous)
+			}
+		}
+		if len(kept) == 0 {
+			delete(target.occurrences, key)
+		} else {
+			target.occurrences[key] = kept
+		}
+	}
+}
+
+// flapHorizon returns the longest period any rule of the target counts the
+// occurrences over
+func (target *Target) flapHorizon() (horizon time.Duration) {
+	for _, rule := range target.Rules {
+		if rule.flapWithin > horizon {
+			horizon = rule.flapWithin
+		}
+	}
+	return horizon
+}
+
+// flapping returns true when the rule does not care about flapping, or when
+// the incident fired at least FlapCount times within FlapWithin
+func (target *Target) flapping(rule *Rule, incident Incident) bool {
+	if rule.FlapCount == 0 {
+		return true
+	}
+	since := now().Add(-rule.flapWithin)
+	count := 0
+	for _, previous := range target.occurrences[flapKey(rule, incident)] {
+		if previous.at.After(since) {
+			count++
+		}
+	}
+	return count >= rule.FlapCount
+}
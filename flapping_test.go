@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlapping(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC) }
+
+	target := newTestTarget()
+	target.Rules = []Rule{{Name: "flapping", FlapCount: 3, FlapWithin: "30m"}}
+	assert.Equal(t, compileRules(target.Rules), true, "Expected the flapping rule to compile")
+
+	incident := newTestIncident()
+	for i, createdAt := range []string{"2026-10-17T11:00:00Z", "2026-10-17T11:40:00Z", "2026-10-17T11:50:00Z"} {
+		incident.ID = "PO7FKW" + string(rune('0'+i))
+		incident.CreatedAt = createdAt
+		target.recordOccurrence(incident)
+		// The same incident is seen again on every refresh
+		target.recordOccurrence(incident)
+	}
+
+	// The first occurrence is too old to be counted
+	rule, act := target.matchRules(incident)
+	assert.Nil(t, rule, "Only two occurrences within 30 minutes")
+	assert.Equal(t, act, false, "The incident should be left to a human")
+
+	incident.ID = "PO7FKW3"
+	incident.CreatedAt = "2026-10-17T11:59:00Z"
+	target.recordOccurrence(incident)
+	rule, act = target.matchRules(incident)
+	assert.Equal(t, rule.Name, "flapping", "Three occurrences within 30 minutes")
+	assert.Equal(t, act, true, "The flapping incident should be acted upon")
+
+	// Another incident key has its own count
+	other := newTestIncident()
+	other.ID = "PO7FKW4"
+	other.IncidentKey = "another-key"
+	target.recordOccurrence(other)
+	rule, act = target.matchRules(other)
+	assert.Nil(t, rule, "First occurrence of another incident key")
+	assert.Equal(t, act, false, "The first occurrence should be left to a human")
+	assert.Equal(t, len(target.occurrences["incidentKey:"+incident.IncidentKey]), 3, "Older occurrences should be forgotten")
+
+	// The incidents no longer seen are forgotten too
+	now = func() time.Time { return time.Date(2026, 10, 17, 13, 0, 0, 0, time.UTC) }
+	target.pruneOccurrences()
+	assert.Empty(t, target.occurrences, "Every occurrence older than 30 minutes should be forgotten")
+}
+
+func TestFlappingBySubject(t *testing.T) {
+	target := newTestTarget()
+	target.Rules = []Rule{{Name: "flapping", FlapCount: 2, FlapWithin: "1h", FlapBy: "subject"}}
+	assert.Equal(t, compileRules(target.Rules), true, "Expected the flapping rule to compile")
+
+	// Same subject, different incident keys
+	for i := 0; i < 2; i++ {
+		incident := newTestIncident()
+		incident.ID = "PO7FKW" + string(rune('0'+i))
+		incident.IncidentKey = incident.ID
+		target.recordOccurrence(incident)
+	}
+	rule, act := target.matchRules(newTestIncident())
+	assert.Equal(t, rule.Name, "flapping", "Two occurrences of the subject within 1 hour")
+	assert.Equal(t, act, true, "The flapping incident should be acted upon")
+}
+
+func TestInvalidFlappingRules(t *testing.T) {
+	rules := []Rule{
+		{Name: "no-within", FlapCount: 3},
+		{Name: "bad-within", FlapCount: 3, FlapWithin: "a while"},
+		{Name: "bad-by", FlapBy: "service"},
+		{Name: "negative", FlapCount: -1},
+	}
+	for _, rule := range rules {
+		assert.Equal(t, compileRuleFlapping(&rule), false, "Expected the rule %s to fail", rule.Name)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthStalePolls is the number of poll delays without a successful poll
+// after which a target is unhealthy
+var healthStalePolls = 3
+
+// healthMinStaleness keeps short refresh delays from flagging a target as
+// unhealthy while a slow poll is still retrying
+var healthMinStaleness = time.Minute
+
+// TargetHealth is the health of a target, as reported by /healthz and
+// /readyz
+type TargetHealth struct {
+	Name               string     `json:"name"`
+	Healthy            bool       `json:"healthy"`
+	Ready              bool       `json:"ready"`
+	Reason             string     `json:"reason,omitempty"`
+	LastSuccessfulPoll *time.Time `json:"last_successful_poll,omitempty"`
+	APIKeyRejected     bool       `json:"api_key_rejected"`
+	AccessDenied       bool       `json:"access_denied"`
+	Stopped            bool       `json:"stopped"`
+
+	startedAt    time.Time
+	refreshDelay time.Duration
+}
+
+// HealthReport is the JSON body of /healthz and /readyz
+type HealthReport struct {
+	Status  string         `json:"status"`
+	Targets []TargetHealth `json:"targets"`
+}
+
+// HealthRegistry follows the polls of every target to tell whether pdack
+// is still doing its job
+type HealthRegistry struct {
+	mutex   sync.Mutex
+	targets map[string]*TargetHealth
+	names   []string
+}
+
+var health = newHealthRegistry()
+
+func newHealthRegistry() *HealthRegistry {
+	return &HealthRegistry{targets: map[string]*TargetHealth{}}
+}
+
+// get returns the health of the target, following it from now on if it was
+// not already
+func (registry *HealthRegistry) get(target *Target) *TargetHealth {
+	name := target.metricLabel()
+	targetHealth, found := registry.targets[name]
+	if !found {
+		targetHealth = &TargetHealth{Name: name, startedAt: now()}
+		registry.targets[name] = targetHealth
+		registry.names = append(registry.names, name)
+	}
+	targetHealth.refreshDelay = target.getPollDelay()
+	return targetHealth
+}
+
+// register starts following the health of the target
+func (registry *HealthRegistry) register(target *Target) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.get(target)
+}
+
+// replace follows the health of the reloaded targets instead of the
+// previous ones, keeping the last successful poll of those still there
+func (registry *HealthRegistry) replace(targets []*Target) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	previous := registry.targets
+	registry.targets = map[string]*TargetHealth{}
+	registry.names = nil
+	for _, target := range targets {
+		targetHealth := registry.get(target)
+		if previousHealth, found := previous[targetHealth.Name]; found {
+			targetHealth.startedAt = previousHealth.startedAt
+			targetHealth.LastSuccessfulPoll = previousHealth.LastSuccessfulPoll
+		}
+	}
+}
+
+// pollSucceeded records a successful poll of the target, PagerDuty having
+// accepted its API key
+func (registry *HealthRegistry) pollSucceeded(target *Target) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	targetHealth := registry.get(target)
+	polledAt := now()
+	targetHealth.LastSuccessfulPoll = &polledAt
+	targetHealth.APIKeyRejected = false
+	targetHealth.AccessDenied = false
+}
+
+// keyRejected records PagerDuty rejected the API key of the target
+func (registry *HealthRegistry) keyRejected(target *Target) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.get(target).APIKeyRejected = true
+}
+
+// accessDenied records PagerDuty denied the API key of the target access to
+// a request
+func (registry *HealthRegistry) accessDenied(target *Target) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.get(target).AccessDenied = true
+}
+
+// stopped records the target is no longer polled
+func (registry *HealthRegistry) stopped(target *Target) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.get(target).Stopped = true
+}
+
+// evaluate updates whether the target is healthy and ready at t. A target
+// is ready once it polled successfully
+func (targetHealth *TargetHealth) evaluate(t time.Time) {
+	staleness := time.Duration(healthStalePolls) * targetHealth.refreshDelay
+	if staleness < healthMinStaleness {
+		staleness = healthMinStaleness
+	}
+	lastPoll := targetHealth.startedAt
+	if targetHealth.LastSuccessfulPoll != nil {
+		lastPoll = *targetHealth.LastSuccessfulPoll
+	}
+
+	targetHealth.Healthy = true
+	targetHealth.Reason = ""
+	switch {
+	case targetHealth.APIKeyRejected:
+		targetHealth.Healthy = false
+		targetHealth.Reason = "the API key has been rejected by PagerDuty"
+	case targetHealth.AccessDenied:
+		targetHealth.Healthy = false
+		targetHealth.Reason = "PagerDuty denied the API key access"
+	case targetHealth.Stopped:
+		targetHealth.Healthy = false
+		targetHealth.Reason = "the incidents are no longer watched"
+	case t.Sub(lastPoll) > staleness:
+		targetHealth.Healthy = false
+		targetHealth.Reason = fmt.Sprintf("no successful poll for %s", t.Sub(lastPoll).Truncate(time.Second))
+	case targetHealth.LastSuccessfulPoll == nil:
+		targetHealth.Reason = "waiting for the first successful poll"
+	}
+	targetHealth.Ready = targetHealth.Healthy && targetHealth.LastSuccessfulPoll != nil
+}
+
+// report returns the health of every target, with an ok status when all of
+// them are healthy, or ready when readiness is requested
+func (registry *HealthRegistry) report(readiness bool) (report HealthReport, ok bool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	ok = true
+	report.Targets = []TargetHealth{}
+	for _, name := range registry.names {
+		targetHealth := registry.targets[name]
+		targetHealth.evaluate(now())
+		if !targetHealth.Healthy || (readiness && !targetHealth.Ready) {
+			ok = false
+		}
+		report.Targets = append(report.Targets, *targetHealth)
+	}
+	report.Status = "ok"
+	if !ok && readiness {
+		report.Status = "not ready"
+	} else if !ok {
+		report.Status = "unhealthy"
+	}
+	return report, ok
+}
+
+func (registry *HealthRegistry) serve(writer http.ResponseWriter, readiness bool) {
+	report, ok := registry.report(readiness)
+	writer.Header().Set("Content-Type", "application/json")
+	if !ok {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(writer).Encode(report)
+}
+
+// healthz answers 200 while every target is healthy, 503 otherwise
+func (registry *HealthRegistry) healthz(writer http.ResponseWriter, req *http.Request) {
+	registry.serve(writer, false)
+}
+
+// readyz answers 200 once every target is healthy and polled successfully,
+// 503 otherwise
+func (registry *HealthRegistry) readyz(writer http.ResponseWriter, req *http.Request) {
+	registry.serve(writer, true)
+}
+
+// checkAPIKey records whether PagerDuty rejected the API key of the target,
+// or denied it access to the incidents or the user, in its response.
+// Neither gets better by sending the request again, the target stops until
+// its configuration is fixed. An update denied only fails its action, which
+// is tried again later
+func (target *Target) checkAPIKey(resp *http.Response) {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		target.logf("PagerDuty rejected the API key of %s, it is invalid or has been revoked. Create a new REST API key in PagerDuty, under Integrations > API Access Keys, and set it as apiKey", target.UserID)
+		health.keyRejected(target)
+		target.fatal = exitAPIKeyRejected
+	case http.StatusForbidden:
+		target.logf("PagerDuty denied the API key of %s access to %s %s. Use a full access REST API key rather than a read-only one, or a user API key of a user allowed to act on the incidents", target.UserID, resp.Request.Method, resp.Request.URL.Path)
+		if isPollRead(resp.Request) {
+			health.accessDenied(target)
+			target.fatal = exitAccessDenied
+		}
+	}
+}
+
+// isPollRead returns true for the requests no poll can do without, listing
+// the incidents and getting the user
+func isPollRead(req *http.Request) bool {
+	return req.Method == http.MethodGet && (strings.HasSuffix(req.URL.Path, "/incidents") || strings.Contains(req.URL.Path, "/users/"))
+}
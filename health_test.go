@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopkg.in/h2non/gock.v0"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthStaleness(t *testing.T) {
+	defer func() { now = time.Now }()
+	start := time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+
+	registry := newHealthRegistry()
+	target := newTestTarget()
+	target.Name = "health"
+	target.RefreshDelay = 60
+	registry.register(target)
+
+	report, ok := registry.report(false)
+	assert.True(t, ok, "A target waiting for its first poll should be healthy")
+	assert.Equal(t, report.Targets[0].Reason, "waiting for the first successful poll", "Invalid reason")
+	_, ok = registry.report(true)
+	assert.False(t, ok, "A target should not be ready before its first poll")
+
+	registry.pollSucceeded(target)
+	_, ok = registry.report(true)
+	assert.True(t, ok, "A target should be ready after its first poll")
+
+	now = func() time.Time { return start.Add(3 * time.Minute) }
+	_, ok = registry.report(false)
+	assert.True(t, ok, "A target polled 3 refresh delays ago should be healthy")
+
+	now = func() time.Time { return start.Add(4 * time.Minute) }
+	report, ok = registry.report(false)
+	assert.False(t, ok, "A target not polled for more than 3 refresh delays should be unhealthy")
+	assert.Equal(t, report.Status, "unhealthy", "Invalid status")
+	assert.Equal(t, report.Targets[0].Reason, "no successful poll for 4m0s", "Invalid reason")
+}
+
+func TestHealthzAPIKeyRejected(t *testing.T) {
+	defer gock.Off()
+	defer func() { health = newHealthRegistry() }()
+	health = newHealthRegistry()
+
+	target := newTestTarget()
+	target.Name = "rejected"
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=" + config.UserID).
+		Reply(401).
+		BodyString(`{"error":{"message":"Unauthorized","code":2006}}`)
+
+	assert.Equal(t, target.getAssignedPDIncidents(), false, "The poll should fail")
+
+	recorder := httptest.NewRecorder()
+	health.healthz(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, recorder.Code, 503, "A rejected API key should be unhealthy")
+	assert.Equal(t, recorder.Header().Get("Content-Type"), "application/json", "Invalid content type")
+
+	var report HealthReport
+	json.Unmarshal(recorder.Body.Bytes(), &report)
+	assert.Equal(t, report.Status, "unhealthy", "Invalid status")
+	assert.Equal(t, len(report.Targets), 1, "Expected the health of a single target")
+	assert.Equal(t, report.Targets[0].Name, "rejected", "Invalid target")
+	assert.True(t, report.Targets[0].APIKeyRejected, "The API key should be reported as rejected")
+	assert.Equal(t, report.Targets[0].Reason, "the API key has been rejected by PagerDuty", "Invalid reason")
+}
+
+func TestReadyz(t *testing.T) {
+	defer gock.Off()
+	defer func() { health = newHealthRegistry() }()
+	health = newHealthRegistry()
+
+	target := newTestTarget()
+	target.Name = "ready"
+	health.register(target)
+
+	recorder := httptest.NewRecorder()
+	health.readyz(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, recorder.Code, 503, "pdack should not be ready before polling")
+
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=" + config.UserID).
+		Reply(200).
+		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":null,"more":false}`)
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "The poll should succeed")
+
+	recorder = httptest.NewRecorder()
+	health.readyz(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, recorder.Code, 200, "pdack should be ready after polling")
+	var report HealthReport
+	json.Unmarshal(recorder.Body.Bytes(), &report)
+	assert.Equal(t, report.Status, "ok", "Invalid status")
+	assert.NotNil(t, report.Targets[0].LastSuccessfulPoll, "The last successful poll should be reported")
+}
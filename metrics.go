@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricDescription is the help text and type of a metric
+type metricDescription struct {
+	help       string
+	metricType string
+}
+
+var metricDescriptions = map[string]metricDescription{
+	"pdack_polls_total":                            {"Number of times the incidents of a target were polled, by outcome.", "counter"},
+	"pdack_poll_duration_seconds":                  {"Time spent polling the incidents of a target and acting on them.", "histogram"},
+	"pdack_last_successful_poll_timestamp_seconds": {"Unix time of the last successful poll of a target.", "gauge"},
+	"pdack_incidents":                              {"Incidents assigned to the user of a target on the last poll, by status.", "gauge"},
+	"pdack_actions_total":                          {"Actions taken on incidents, by action and outcome. Their sum is the number of actions attempted.", "counter"},
+	"pdack_retries_total":                          {"Requests to PagerDuty retried after a recoverable error.", "counter"},
+	"pdack_rate_limit_wait_seconds_total":          {"Time spent waiting for the rate limit of the API key before sending requests.", "counter"},
+}
+
+// pollDurationBuckets are the upper bounds of the poll duration histogram
+var pollDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// metricKey identifies a time series, labels being already formatted
+type metricKey struct {
+	name   string
+	labels string
+}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// MetricsRegistry holds the metrics of the process, rendered in the
+// Prometheus text format
+type MetricsRegistry struct {
+	mutex      sync.Mutex
+	values     map[metricKey]float64
+	histograms map[metricKey]*histogram
+}
+
+var metrics = newMetricsRegistry()
+
+func newMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		values:     map[metricKey]float64{},
+		histograms: map[metricKey]*histogram{},
+	}
+}
+
+// metricLabels formats label names and values given in pairs
+func metricLabels(pairs ...string) string {
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		labels = append(labels, pairs[i]+`="`+value+`"`)
+	}
+	return strings.Join(labels, ",")
+}
+
+func (registry *MetricsRegistry) add(name string, labels string, value float64) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.values[metricKey{name, labels}] += value
+}
+
+func (registry *MetricsRegistry) set(name string, labels string, value float64) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.values[metricKey{name, labels}] = value
+}
+
+func (registry *MetricsRegistry) get(name string, labels string) float64 {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	return registry.values[metricKey{name, labels}]
+}
+
+func (registry *MetricsRegistry) observe(name string, labels string, value float64) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	key := metricKey{name, labels}
+	observed, found := registry.histograms[key]
+	if !found {
+		observed = &histogram{buckets: make([]uint64, len(pollDurationBuckets))}
+		registry.histograms[key] = observed
+	}
+	for i, bound := range pollDurationBuckets {
+		if value <= bound {
+			observed.buckets[i]++
+		}
+	}
+	observed.count++
+	observed.sum += value
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func withLabel(labels string, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+// render writes every metric in the Prometheus text format
+func (registry *MetricsRegistry) render(writer io.Writer) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	series := map[string][]string{}
+	for key, value := range registry.values {
+		series[key.name] = append(series[key.name], fmt.Sprintf("%s{%s} %s", key.name, key.labels, formatMetricValue(value)))
+	}
+	for key, observed := range registry.histograms {
+		for i, bound := range pollDurationBuckets {
+			series[key.name] = append(series[key.name], fmt.Sprintf("%s_bucket{%s} %d", key.name, withLabel(key.labels, `le="`+formatMetricValue(bound)+`"`), observed.buckets[i]))
+		}
+		series[key.name] = append(series[key.name],
+			fmt.Sprintf("%s_bucket{%s} %d", key.name, withLabel(key.labels, `le="+Inf"`), observed.count),
+			fmt.Sprintf("%s_sum{%s} %s", key.name, key.labels, formatMetricValue(observed.sum)),
+			fmt.Sprintf("%s_count{%s} %d", key.name, key.labels, observed.count))
+	}
+
+	var names []string
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		description := metricDescriptions[name]
+		fmt.Fprintf(writer, "# HELP %s %s\n", name, description.help)
+		fmt.Fprintf(writer, "# TYPE %s %s\n", name, description.metricType)
+		lines := series[name]
+		if description.metricType != "histogram" {
+			// Histogram lines are kept in order, buckets first
+			sort.Strings(lines)
+		}
+		for _, line := range lines {
+			fmt.Fprintln(writer, line)
+		}
+	}
+}
+
+func (registry *MetricsRegistry) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	registry.render(writer)
+}
+
+// metricLabel returns the value of the target label of the metrics
+func (target *Target) metricLabel() string {
+	if target.Name != "" {
+		return target.Name
+	}
+	return target.UserID
+}
+
+// countRetry counts a request retried after a recoverable error
+func (target *Target) countRetry() {
+	metrics.add("pdack_retries_total", metricLabels("target", target.metricLabel()), 1)
+}
+
+// recordPoll records the outcome and duration of a poll started at start
+func (target *Target) recordPoll(start time.Time, success bool) {
+	labels := metricLabels("target", target.metricLabel())
+	metrics.observe("pdack_poll_duration_seconds", labels, time.Since(start).Seconds())
+	if success {
+		metrics.add("pdack_polls_total", metricLabels("target", target.metricLabel(), "outcome", "success"), 1)
+		metrics.set("pdack_last_successful_poll_timestamp_seconds", labels, float64(now().Unix()))
+		health.pollSucceeded(target)
+	} else {
+		metrics.add("pdack_polls_total", metricLabels("target", target.metricLabel(), "outcome", "failure"), 1)
+	}
+}
+
+// newServeMux returns the handlers of the metrics and the health endpoints
+func newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	mux.HandleFunc("/healthz", health.healthz)
+	mux.HandleFunc("/readyz", health.readyz)
+	return mux
+}
+
+// startHTTPServer listens on address and serves the handlers in the
+// background
+func startHTTPServer(address string, handler http.Handler) (server *http.Server, success bool) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Printf("An error occured while listening on %s: %s", address, err)
+		return nil, false
+	}
+	server = &http.Server{Handler: handler}
+	go server.Serve(listener)
+	log.Printf("Listening on http://%s", listener.Addr())
+	return server, true
+}
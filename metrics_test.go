@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/h2non/gock.v0"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsRender(t *testing.T) {
+	registry := newMetricsRegistry()
+	registry.add("pdack_retries_total", metricLabels("target", "b"), 1)
+	registry.add("pdack_retries_total", metricLabels("target", `a"1`), 2)
+	registry.observe("pdack_poll_duration_seconds", metricLabels("target", "a"), 0.3)
+
+	var output bytes.Buffer
+	registry.render(&output)
+	expected := []string{
+		"# HELP pdack_poll_duration_seconds Time spent polling the incidents of a target and acting on them.",
+		"# TYPE pdack_poll_duration_seconds histogram",
+		`pdack_poll_duration_seconds_bucket{target="a",le="0.1"} 0`,
+		`pdack_poll_duration_seconds_bucket{target="a",le="0.25"} 0`,
+		`pdack_poll_duration_seconds_bucket{target="a",le="0.5"} 1`,
+		`pdack_poll_duration_seconds_bucket{target="a",le="1"} 1`,
+		`pdack_poll_duration_seconds_bucket{target="a",le="2.5"} 1`,
+		`pdack_poll_duration_seconds_bucket{target="a",le="5"} 1`,
+		`pdack_poll_duration_seconds_bucket{target="a",le="10"} 1`,
+		`pdack_poll_duration_seconds_bucket{target="a",le="30"} 1`,
+		`pdack_poll_duration_seconds_bucket{target="a",le="60"} 1`,
+		`pdack_poll_duration_seconds_bucket{target="a",le="+Inf"} 1`,
+		`pdack_poll_duration_seconds_sum{target="a"} 0.3`,
+		`pdack_poll_duration_seconds_count{target="a"} 1`,
+		"# HELP pdack_retries_total Requests to PagerDuty retried after a recoverable error.",
+		"# TYPE pdack_retries_total counter",
+		`pdack_retries_total{target="a\"1"} 2`,
+		`pdack_retries_total{target="b"} 1`,
+	}
+	assert.Equal(t, output.String(), strings.Join(expected, "\n")+"\n", "Invalid Prometheus text format")
+}
+
+func TestMetricsHandler(t *testing.T) {
+	registry := newMetricsRegistry()
+	registry.set("pdack_incidents", metricLabels("target", "a", "status", "triggered"), 3)
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, recorder.Code, 200, "The metrics should be served")
+	assert.Equal(t, recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4", "Invalid content type")
+	assert.Contains(t, recorder.Body.String(), `pdack_incidents{target="a",status="triggered"} 3`, "The gauge should be rendered")
+}
+
+func TestGetAssignedPDIncidentsMetrics(t *testing.T) {
+	defer gock.Off()
+	defer func() { metrics = newMetricsRegistry() }()
+	defer func() { now = time.Now }()
+	metrics = newMetricsRegistry()
+	now = func() time.Time { return time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC) }
+
+	target := newTestTarget()
+	target.Name = "metrics"
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=" + config.UserID).
+		Reply(500).
+		BodyString(`{"error":{"message":"Internal Server Error"}}`)
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=" + config.UserID).
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","incident_number":111661,"title":"t","status":"triggered","service":{"id":"P7C31P0","summary":"TEST_SERVICE"}},{"id":"PO7FKW0","type":"incident","incident_number":111662,"title":"t","status":"acknowledged","service":{"id":"P7C31P0","summary":"TEST_SERVICE"}}],"limit":100,"offset":0,"total":null,"more":false}`)
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		Reply(200).
+		BodyString(`{"incidents":[]}`)
+
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "The poll should succeed")
+	assert.Equal(t, metrics.get("pdack_polls_total", metricLabels("target", "metrics", "outcome", "success")), float64(1), "The poll should be counted")
+	assert.Equal(t, metrics.get("pdack_retries_total", metricLabels("target", "metrics")), float64(1), "The retry should be counted")
+	assert.Equal(t, metrics.get("pdack_incidents", metricLabels("target", "metrics", "status", "triggered")), float64(1), "Invalid number of triggered incidents")
+	assert.Equal(t, metrics.get("pdack_incidents", metricLabels("target", "metrics", "status", "acknowledged")), float64(1), "Invalid number of acknowledged incidents")
+	assert.Equal(t, metrics.get("pdack_actions_total", metricLabels("target", "metrics", "action", actionAcknowledge, "outcome", "success")), float64(1), "The acknowledgement should be counted")
+	assert.Equal(t, metrics.get("pdack_last_successful_poll_timestamp_seconds", metricLabels("target", "metrics")), float64(now().Unix()), "Invalid time of the last successful poll")
+}
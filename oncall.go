@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+
+	"github.com/slariviere/pdack/pagerduty"
+)
+
+// OnCall is an on-call shift, as returned by the pagerduty package
+type OnCall = pagerduty.OnCall
+
+// shiftMatches returns true when the shift is on one of the schedules and
+// escalation policies the target is restricted to, by ID or name
+func shiftMatches(onCall OnCall, schedules []string, escalationPolicies []string) bool {
+	if len(schedules) > 0 && (onCall.Schedule.ID == "" || !matchesAny(schedules, onCall.Schedule.ID, onCall.Schedule.Summary)) {
+		return false
+	}
+	return matchesAny(escalationPolicies, onCall.EscalationPolicy.ID, onCall.EscalationPolicy.Summary)
+}
+
+// isOnCall returns true when the user of the target currently holds an
+// on-call shift
+func (target *Target) isOnCall() (onCall bool, success bool) {
+	var onCalls pagerduty.OnCallList
+	success = target.request("get the on-call shifts of "+target.UserID, func(ctx context.Context) (err error) {
+		onCalls, err = target.client.ListOnCalls(ctx, target.UserID)
+		return err
+	})
+	if !success {
+		return false, false
+	}
+	for _, shift := range onCalls.OnCalls {
+		if shiftMatches(shift, target.OnCallSchedules, target.OnCallEscalationPolicies) {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// checkOnCall returns whether the target should act on its incidents, only
+// while its user is on call when onCallOnly is set
+func (target *Target) checkOnCall() (active bool, success bool) {
+	if !target.OnCallOnly {
+		return true, true
+	}
+	onCall, success := target.isOnCall()
+	if !success {
+		return false, false
+	}
+	if onCall && target.idle {
+		target.logf("%s is on call, resuming", target.UserID)
+	} else if !onCall && !target.idle {
+		target.logf("%s is not on call, idling until the next shift", target.UserID)
+	}
+	target.idle = !onCall
+	return onCall, true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/h2non/gock.v0"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testOnCalls = `{"oncalls":[{"escalation_policy":{"id":"P5W7JL2","type":"escalation_policy_reference","summary":"MO - Sebastien Lariviere"},"escalation_level":1,"schedule":{"id":"PI7DH85","type":"schedule_reference","summary":"Daily Engineering Rotation"},"user":{"id":"XXXXXXX","type":"user_reference"},"start":"2016-04-03T00:00:00Z","end":"2016-04-04T00:00:00Z"}],"limit":100,"offset":0,"more":false}`
+
+func TestIsOnCall(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	for i := 0; i < 4; i++ {
+		gock.New("https://api.pagerduty.com/oncalls?user_ids[]="+config.UserID).
+			MatchHeader("Authorization", config.APIKey).
+			Reply(200).
+			BodyString(testOnCalls)
+	}
+
+	onCall, res := target.isOnCall()
+	assert.Equal(t, res, true, "Response code is 200, isOnCall should succeed")
+	assert.Equal(t, onCall, true, "The user holds a shift")
+
+	target.OnCallSchedules = []string{"Daily Engineering Rotation"}
+	onCall, _ = target.isOnCall()
+	assert.Equal(t, onCall, true, "The user holds a shift on the schedule")
+
+	target.OnCallSchedules = []string{"PNOTHERE"}
+	onCall, _ = target.isOnCall()
+	assert.Equal(t, onCall, false, "The user holds no shift on the schedule")
+
+	target.OnCallSchedules = nil
+	target.OnCallEscalationPolicies = []string{"P5W7JL2"}
+	onCall, _ = target.isOnCall()
+	assert.Equal(t, onCall, true, "The user holds a shift on the escalation policy")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+func TestIsOnCallFails(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/oncalls?user_ids[]=" + config.UserID).
+		Reply(403).
+		BodyString(`{"error":{"message":"Access Denied","code":2010}}`)
+
+	_, res := target.isOnCall()
+	assert.Equal(t, res, false, "Response code is 403, isOnCall should fail")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+func TestGetAssignedPDIncidentsOffCall(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	target.OnCallOnly = true
+
+	// The incidents are not even fetched while the user is off call
+	for i := 0; i < 2; i++ {
+		gock.New("https://api.pagerduty.com/oncalls?user_ids[]=" + config.UserID).
+			Reply(200).
+			BodyString(`{"oncalls":[],"limit":100,"offset":0,"more":false}`)
+	}
+
+	b.Reset()
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Being off call is not a failure")
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Being off call is not a failure")
+	traceBuffer.Flush()
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+	assert.Equal(t, strings.Count(b.String(), "is not on call, idling"), 1, "Expected going idle to be logged once: %s", b.String())
+
+	// Back on call, the incidents are handled again
+	gock.New("https://api.pagerduty.com/oncalls?user_ids[]=" + config.UserID).
+		Reply(200).
+		BodyString(testOnCalls)
+
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=" + config.UserID).
+		Reply(200).
+		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":null,"more":false}`)
+
+	b.Reset()
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Incidents should be handled once back on call")
+	traceBuffer.Flush()
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+	assert.True(t, strings.Contains(b.String(), "is on call, resuming"), "Expected resuming to be logged: %s", b.String())
+}
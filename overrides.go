@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// configFlags are the flags overriding the keys of the configuration file,
+// by field of PagerDutyConfig, registered on configFlagSet. The rules,
+// windows and targets can only be set in the file
+var configFlagSet = flag.CommandLine
+var configFlags = registerConfigFlags(configFlagSet)
+
+var configEnvPrefix = "PDACK_"
+
+var wordBoundaries = []*regexp.Regexp{
+	regexp.MustCompile(`([a-z0-9])([A-Z])`),
+	regexp.MustCompile(`([A-Z]+)([A-Z][a-z])`),
+}
+
+// configFlag is the raw value of a flag overriding a configuration key,
+// parsed once the file is read
+type configFlag struct {
+	value     string
+	isBoolean bool
+}
+
+func (f *configFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *configFlag) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+// IsBoolFlag lets -on-call-only be given without value
+func (f *configFlag) IsBoolFlag() bool {
+	return f.isBoolean
+}
+
+func registerConfigFlags(flagSet *flag.FlagSet) (flags map[string]*configFlag) {
+	flags = map[string]*configFlag{}
+	configType := reflect.TypeOf(PagerDutyConfig{})
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		if !isOverridable(field.Type) {
+			continue
+		}
+		flags[field.Name] = &configFlag{isBoolean: field.Type.Kind() == reflect.Bool}
+		flagSet.Var(flags[field.Name], configFlagName(field.Name),
+			fmt.Sprintf("Overrides %s of the configuration file, also set by %s", configKeyName(field.Name), configEnvName(field.Name)))
+	}
+	return flags
+}
+
+func isOverridable(fieldType reflect.Type) bool {
+	switch fieldType.Kind() {
+	case reflect.String, reflect.Int, reflect.Bool:
+		return true
+	case reflect.Slice:
+		return fieldType.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// configWords splits the name of a field in words, APIKey being API and Key
+func configWords(fieldName string) []string {
+	for _, boundary := range wordBoundaries {
+		fieldName = boundary.ReplaceAllString(fieldName, "${1} ${2}")
+	}
+	return strings.Fields(fieldName)
+}
+
+// configKeyName returns the key of the field in the configuration file,
+// apiKey for APIKey
+func configKeyName(fieldName string) string {
+	words := configWords(fieldName)
+	words[0] = strings.ToLower(words[0])
+	return strings.Join(words, "")
+}
+
+// configEnvName returns the environment variable of the field, PDACK_API_KEY
+// for APIKey
+func configEnvName(fieldName string) string {
+	return configEnvPrefix + strings.ToUpper(strings.Join(configWords(fieldName), "_"))
+}
+
+// configFlagName returns the flag of the field, api-key for APIKey
+func configFlagName(fieldName string) string {
+	return strings.ToLower(strings.Join(configWords(fieldName), "-"))
+}
+
+// applyOverrides sets the keys of the configuration given in the environment
+// or on the command line, the flags taking precedence over the environment
+// which takes precedence over the file. It returns the keys overridden
+func applyOverrides() (overridden map[string]bool, success bool) {
+	setFlags := map[string]bool{}
+	configFlagSet.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	overridden = map[string]bool{}
+	success = true
+	configValue := reflect.ValueOf(&config).Elem()
+	configType := configValue.Type()
+	for i := 0; i < configType.NumField(); i++ {
+		fieldName := configType.Field(i).Name
+		flagValue, found := configFlags[fieldName]
+		if !found {
+			continue
+		}
+		source, value, set := configEnvName(fieldName), "", false
+		if envValue, found := os.LookupEnv(source); found {
+			value, set = envValue, true
+		}
+		if setFlags[configFlagName(fieldName)] {
+			source, value, set = "-"+configFlagName(fieldName), flagValue.value, true
+		}
+		if !set {
+			continue
+		}
+		if err := setConfigField(configValue.Field(i), value); err != nil {
+			log.Printf("An error occured while reading %s, %s", source, err)
+			success = false
+			continue
+		}
+		overridden[configKeyName(fieldName)] = true
+	}
+	return overridden, success
+}
+
+// setConfigField parses value into the field, lists being comma separated
+func setConfigField(field reflect.Value, value string) (err error) {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s is not a number", value)
+		}
+		field.SetInt(int64(parsed))
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s is not a boolean", value)
+		}
+		field.SetBool(parsed)
+	case reflect.Slice:
+		var values []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	}
+	return nil
+}
+
+// getConfigFilePath returns the path of the configuration file, given with
+// -conf or PDACK_CONF. A relative path is looked up in the working directory
+// first, then in $XDG_CONFIG_HOME/pdack, ~/.config/pdack by default
+func getConfigFilePath() string {
+	path := *filename
+	if envPath, found := os.LookupEnv(configEnvPrefix + "CONF"); found && !isFlagSet("conf") {
+		path = envPath
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	pwd, _ := os.Getwd()
+	candidates := []string{filepath.Join(pwd, path)}
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		candidates = append(candidates, filepath.Join(configHome, "pdack", path))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".config", "pdack", path))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return candidates[0]
+}
+
+func isFlagSet(name string) (set bool) {
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigNames(t *testing.T) {
+	assert.Equal(t, configKeyName("APIKey"), "apiKey", "Invalid key name")
+	assert.Equal(t, configKeyName("UserID"), "userID", "Invalid key name")
+	assert.Equal(t, configEnvName("APIKey"), "PDACK_API_KEY", "Invalid environment variable")
+	assert.Equal(t, configEnvName("OnCallEscalationPolicies"), "PDACK_ON_CALL_ESCALATION_POLICIES", "Invalid environment variable")
+	assert.Equal(t, configFlagName("RefreshDelay"), "refresh-delay", "Invalid flag")
+	_, found := configFlags["Rules"]
+	assert.False(t, found, "The rules can only be set in the file")
+}
+
+// withConfigFlags parses args with a fresh set of configuration flags
+func withConfigFlags(args []string, test func()) {
+	previousFlagSet, previousFlags := configFlagSet, configFlags
+	defer func() { configFlagSet, configFlags = previousFlagSet, previousFlags }()
+	configFlagSet = flag.NewFlagSet("pdack", flag.ContinueOnError)
+	configFlags = registerConfigFlags(configFlagSet)
+	configFlagSet.Parse(args)
+	test()
+}
+
+func TestConfigOverrides(t *testing.T) {
+	defer func(previous PagerDutyConfig) { config = previous }(config)
+	defer os.Unsetenv("PDACK_REFRESH_DELAY")
+	defer os.Unsetenv("PDACK_API_KEY")
+	defer os.Unsetenv("PDACK_ON_CALL_SCHEDULES")
+	os.Setenv("PDACK_REFRESH_DELAY", "30")
+	os.Setenv("PDACK_API_KEY", "from-env")
+	os.Setenv("PDACK_ON_CALL_SCHEDULES", "Primary, Secondary")
+	pwd, _ := os.Getwd()
+
+	withConfigFlags([]string{"-api-key=from-flag", "-on-call-only"}, func() {
+		config = PagerDutyConfig{}
+		_, success := readConfigFile(pwd + "/pdack_sample.conf")
+		assert.True(t, success, "The configuration should be valid")
+		assert.Equal(t, config.APIKey, "from-flag", "The flag should take precedence over the environment")
+		assert.Equal(t, config.RefreshDelay, 30, "The environment should take precedence over the file")
+		assert.Equal(t, config.UserID, "XXXXXXX", "The file should be used when nothing overrides it")
+		assert.True(t, config.OnCallOnly, "A boolean flag without value should be true")
+		assert.Equal(t, config.OnCallSchedules, []string{"Primary", "Secondary"}, "Lists should be comma separated")
+	})
+
+	os.Setenv("PDACK_REFRESH_DELAY", "soon")
+	withConfigFlags(nil, func() {
+		config = PagerDutyConfig{}
+		_, success := readConfigFile(pwd + "/pdack_sample.conf")
+		assert.False(t, success, "An invalid number should be rejected")
+	})
+}
+
+func TestConfigOverridesMissingKeys(t *testing.T) {
+	defer func(previous PagerDutyConfig) { config = previous }(config)
+	defer os.Unsetenv("PDACK_USER_ID")
+	os.Setenv("PDACK_USER_ID", "XXXXXXX")
+	pwd, _ := os.Getwd()
+
+	withConfigFlags([]string{"-refresh-delay=60"}, func() {
+		config = PagerDutyConfig{}
+		traceBuffer.Flush()
+		b.Reset()
+		_, success := readConfigFile(pwd + "/_example/missing_email.conf")
+		traceBuffer.Flush()
+		assert.True(t, success, "The missing keys should be given by the environment and the flags")
+		assert.Contains(t, b.String(), "The account key is ignored", "The account key should be reported as ignored")
+	})
+}
+
+func TestGetConfigFilePath(t *testing.T) {
+	defer func(previous string) { *filename = previous }(*filename)
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+	directory, _ := ioutil.TempDir("", "pdack")
+	defer os.RemoveAll(directory)
+	os.MkdirAll(filepath.Join(directory, "pdack"), 0700)
+	ioutil.WriteFile(filepath.Join(directory, "pdack", "xdg.conf"), []byte(""), 0600)
+	os.Setenv("XDG_CONFIG_HOME", directory)
+	pwd, _ := os.Getwd()
+
+	*filename = "/etc/pdack/pdack.conf"
+	assert.Equal(t, getConfigFilePath(), "/etc/pdack/pdack.conf", "Absolute paths should be kept")
+	*filename = "pdack_sample.conf"
+	assert.Equal(t, getConfigFilePath(), filepath.Join(pwd, "pdack_sample.conf"), "The working directory should be looked up first")
+	*filename = "xdg.conf"
+	assert.Equal(t, getConfigFilePath(), filepath.Join(directory, "pdack", "xdg.conf"), "$XDG_CONFIG_HOME/pdack should be looked up")
+	*filename = "missing.conf"
+	assert.Equal(t, getConfigFilePath(), filepath.Join(pwd, "missing.conf"), "A missing file should be reported in the working directory")
+}
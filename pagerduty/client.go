@@ -0,0 +1,126 @@
+// Package pagerduty is a client of the PagerDuty REST API v2, covering what
+// pdack needs to act on the incidents of a user
+package pagerduty
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the URL of the PagerDuty REST API
+const DefaultBaseURL = "https://api.pagerduty.com"
+
+// API is the part of the PagerDuty REST API used by pdack, implemented by
+// Client and by the stubs of the tests. The updates are made on behalf of
+// the user whose email is from, required with an account API key
+type API interface {
+	ListIncidents(ctx context.Context, options ListIncidentsOptions) (IncidentList, error)
+	GetIncident(ctx context.Context, id string) (Incident, error)
+	Acknowledge(ctx context.Context, from string, id string) error
+	AcknowledgeAll(ctx context.Context, from string, ids []string) (map[string]error, error)
+	Resolve(ctx context.Context, from string, id string) error
+	Snooze(ctx context.Context, from string, id string, duration time.Duration) error
+	Reassign(ctx context.Context, from string, id string, userID string, escalationPolicyID string) error
+	SetUrgency(ctx context.Context, from string, id string, urgency string) error
+	AddNote(ctx context.Context, from string, id string, content string) error
+	GetUser(ctx context.Context, id string) (User, error)
+	GetCurrentUser(ctx context.Context) (User, error)
+	ListOnCalls(ctx context.Context, userID string) (OnCallList, error)
+}
+
+// Client sends the requests to the PagerDuty REST API with an API key
+type Client struct {
+	APIKey string
+	// BaseURL is DefaultBaseURL unless the requests go through a proxy or a
+	// test server
+	BaseURL    string
+	HTTPClient *http.Client
+	// Observe is called with every response received, before it is decoded
+	Observe func(resp *http.Response)
+}
+
+// NewHTTPClient returns an HTTP client giving up on connections not
+// established within connectTimeout. The requests themselves are bounded by
+// their context
+func NewHTTPClient(connectTimeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+	return &http.Client{Transport: transport}
+}
+
+// NewClient returns a client of the PagerDuty REST API using the API key
+func NewClient(apiKey string) *Client {
+	return &Client{
+		APIKey:     apiKey,
+		BaseURL:    DefaultBaseURL,
+		HTTPClient: &http.Client{},
+	}
+}
+
+// buildURL returns the URL of the resource with the query
+func (client *Client) buildURL(resource string, query url.Values) string {
+	u, _ := url.ParseRequestURI(strings.TrimSuffix(client.BaseURL, "/") + resource)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// do sends a request to the API on behalf of the user whose email is from,
+// if any, decoding the response into result when it is not nil. Responses
+// other than 2xx are returned as an *APIError and never decoded, a 2xx
+// response which does not decode is an error too
+func (client *Client) do(ctx context.Context, from string, method string, resource string, query url.Values, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, client.buildURL(resource, query), reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.pagerduty+json;version=2")
+	req.Header.Set("Content-type", "application/json")
+	req.Header.Set("Authorization", "Token token="+client.APIKey)
+	if from != "" {
+		req.Header.Set("From", from)
+	}
+
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if client.Observe != nil {
+		client.Observe(resp)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(resp, content)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(content, result); err != nil {
+		return fmt.Errorf("unable to decode the response with status code %d, %w", resp.StatusCode, err)
+	}
+	return nil
+}
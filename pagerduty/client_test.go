@@ -0,0 +1,152 @@
+package pagerduty
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestClient returns a client of a test server answering every request
+// with handler
+func newTestClient(handler http.HandlerFunc) (*Client, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	client := NewClient("123")
+	client.BaseURL = server.URL
+	client.HTTPClient = server.Client()
+	return client, server
+}
+
+func TestClientHeaders(t *testing.T) {
+	var requests []*http.Request
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		w.Write([]byte(`{}`))
+	})
+	defer server.Close()
+
+	assert.Nil(t, client.do(context.Background(), "", http.MethodGet, "/incidents", nil, nil, nil), "The request should succeed")
+	assert.Nil(t, client.do(context.Background(), "sebastien@lariviere.me", http.MethodPut, "/incidents", nil, map[string]string{}, nil), "The request should succeed")
+	assert.Equal(t, requests[0].Header.Get("Authorization"), "Token token=123", "Invalid Authorization header")
+	assert.Equal(t, requests[0].Header.Get("Accept"), "application/vnd.pagerduty+json;version=2", "Invalid Accept header")
+	assert.Equal(t, requests[0].Header.Get("From"), "", "Read requests are sent on behalf of nobody")
+	assert.Equal(t, requests[1].Header.Get("From"), "sebastien@lariviere.me", "Write requests are sent on behalf of the user")
+}
+
+func TestClientObserve(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	defer server.Close()
+	var statusCode int
+	client.Observe = func(resp *http.Response) {
+		statusCode = resp.StatusCode
+	}
+
+	assert.Nil(t, client.do(context.Background(), "", http.MethodPost, "/incidents/PO7FKW9/notes", nil, nil, nil), "The request should succeed")
+	assert.Equal(t, statusCode, http.StatusCreated, "Every response should be observed")
+}
+
+func TestClientAPIError(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":{"message":"Access Denied","code":2010}}`))
+	})
+	defer server.Close()
+
+	err := client.do(context.Background(), "", http.MethodGet, "/oncalls", nil, nil, nil)
+	apiErr, ok := err.(*APIError)
+	if assert.True(t, ok, "The error should be an *APIError") {
+		assert.Equal(t, apiErr.StatusCode, http.StatusForbidden, "Invalid status code")
+		assert.Equal(t, apiErr.Code, 2010, "Invalid PagerDuty error code")
+		assert.Equal(t, apiErr.Error(), "status code 403, Access Denied (2010)", "Invalid message")
+		assert.False(t, apiErr.Temporary(), "Access Denied is not temporary")
+	}
+	assert.True(t, (&APIError{StatusCode: 500}).Temporary(), "Internal errors are temporary")
+	assert.Equal(t, (&APIError{StatusCode: 502}).Error(), "status code 502", "Invalid message without body")
+}
+
+func TestClientErrorDetails(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"Invalid Input Provided","code":2001,"errors":["Statuses is invalid.","Limit must be less than 100."]}}`))
+	})
+	defer server.Close()
+
+	var result IncidentList
+	err := client.do(context.Background(), "", http.MethodGet, "/incidents", nil, nil, &result)
+	apiErr, ok := err.(*APIError)
+	if assert.True(t, ok, "The error should be an *APIError") {
+		assert.Equal(t, apiErr.Errors, []string{"Statuses is invalid.", "Limit must be less than 100."}, "Invalid details")
+		assert.Equal(t, apiErr.Error(), "status code 400, Invalid Input Provided (2001): Statuses is invalid., Limit must be less than 100.", "Invalid message")
+	}
+}
+
+func TestClientUndecodableResponses(t *testing.T) {
+	statusCode := http.StatusBadGateway
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(statusCode)
+		w.Write([]byte(`<html><body><h1>502 Bad Gateway</h1></body></html>`))
+	})
+	defer server.Close()
+
+	var result IncidentList
+	err := client.do(context.Background(), "", http.MethodGet, "/incidents", nil, nil, &result)
+	apiErr, ok := err.(*APIError)
+	if assert.True(t, ok, "An error page should be an *APIError") {
+		assert.Equal(t, apiErr.Error(), "status code 502", "The HTML body should be ignored")
+	}
+
+	statusCode = http.StatusOK
+	err = client.do(context.Background(), "", http.MethodGet, "/incidents", nil, nil, &result)
+	if assert.Error(t, err, "A 2xx response which does not decode should be an error") {
+		assert.Contains(t, err.Error(), "unable to decode the response with status code 200", "Invalid message")
+	}
+	assert.False(t, Temporary(err), "An undecodable response is not temporary")
+}
+
+func TestClientBaseURL(t *testing.T) {
+	client := NewClient("123")
+	assert.Equal(t, client.buildURL("/incidents", nil), "https://api.pagerduty.com/incidents", "Invalid default URL")
+	client.BaseURL = "http://proxy.local/pagerduty/"
+	assert.Equal(t, client.buildURL("/incidents", nil), "http://proxy.local/pagerduty/incidents", "The base URL path should be kept")
+}
+
+// readBody returns the body of the request
+func readBody(r *http.Request) string {
+	body, _ := ioutil.ReadAll(r.Body)
+	return string(body)
+}
+
+func TestClientTransportErrors(t *testing.T) {
+	release := make(chan struct{})
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := client.do(ctx, "", http.MethodGet, "/incidents", nil, nil, nil)
+	assert.NotNil(t, err, "The request should time out")
+	assert.True(t, Temporary(err), "A timeout is temporary")
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = client.do(canceled, "", http.MethodGet, "/incidents", nil, nil, nil)
+	assert.False(t, Temporary(err), "A canceled request should not be sent again")
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	client.BaseURL = closed.URL
+	client.HTTPClient = NewHTTPClient(time.Second)
+	err = client.do(context.Background(), "", http.MethodGet, "/incidents", nil, nil, nil)
+	assert.True(t, Temporary(err), "A connection refused is temporary")
+	assert.False(t, Temporary(&APIError{StatusCode: 404}), "A missing resource is not temporary")
+}
@@ -0,0 +1,89 @@
+package pagerduty
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIError is a response of the API other than 2xx, with the error
+// PagerDuty described in its body when there is one. The body of an error
+// page sent by a proxy, in HTML for instance, is ignored
+type APIError struct {
+	StatusCode int
+	// Code is the PagerDuty error code, 2010 for Access Denied for instance
+	Code    int
+	Message string
+	// Errors are the details PagerDuty gave, the invalid fields for instance
+	Errors []string
+	// RetryAfter is how long PagerDuty asked to wait before sending the
+	// request again, with the Retry-After header of a 429 for instance
+	RetryAfter time.Duration
+}
+
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	var response struct {
+		Error struct {
+			Code    int      `json:"code"`
+			Message string   `json:"message"`
+			Errors  []string `json:"errors"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &response)
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Code:       response.Error.Code,
+		Message:    response.Error.Message,
+		Errors:     response.Error.Errors,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter reads a Retry-After header, a number of seconds or a date
+func parseRetryAfter(header string) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil && time.Until(date) > 0 {
+		return time.Until(date)
+	}
+	return 0
+}
+
+func (err *APIError) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("status code %d", err.StatusCode)
+	}
+	if len(err.Errors) == 0 {
+		return fmt.Sprintf("status code %d, %s (%d)", err.StatusCode, err.Message, err.Code)
+	}
+	return fmt.Sprintf("status code %d, %s (%d): %s", err.StatusCode, err.Message, err.Code, strings.Join(err.Errors, ", "))
+}
+
+// Temporary returns true when the request may succeed if sent again
+func (err *APIError) Temporary() bool {
+	switch err.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Temporary returns true when sending the request again may succeed, after
+// a temporary APIError or an error of the transport like a connection
+// refused, a DNS failure or a timeout
+func Temporary(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	var transportErr *url.Error
+	return errors.As(err, &transportErr) && !errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,214 @@
+package pagerduty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Reference is the v2 representation of an object linked to another one
+// (service, escalation policy, user, log entry...)
+type Reference struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Summary string `json:"summary"`
+	Self    string `json:"self"`
+	HTMLURL string `json:"html_url"`
+}
+
+// LogEntry type, the first trigger log entry of an incident is fully
+// included in the incidents list to know how the incident was triggered
+type LogEntry struct {
+	Reference
+	CreatedAt string `json:"created_at"`
+	Channel   struct {
+		Type string `json:"type"`
+	} `json:"channel"`
+}
+
+// Incident type, as returned by the REST API v2
+type Incident struct {
+	ID               string    `json:"id"`
+	Type             string    `json:"type"`
+	Summary          string    `json:"summary"`
+	Self             string    `json:"self"`
+	HTMLURL          string    `json:"html_url"`
+	IncidentNumber   int       `json:"incident_number"`
+	Title            string    `json:"title"`
+	Description      string    `json:"description"`
+	CreatedAt        string    `json:"created_at"`
+	Status           string    `json:"status"`
+	IncidentKey      string    `json:"incident_key"`
+	Urgency          string    `json:"urgency"`
+	Service          Reference `json:"service"`
+	EscalationPolicy Reference `json:"escalation_policy"`
+	Assignments      []struct {
+		At       string    `json:"at"`
+		Assignee Reference `json:"assignee"`
+	} `json:"assignments"`
+	Acknowledgements []struct {
+		At           string    `json:"at"`
+		Acknowledger Reference `json:"acknowledger"`
+	} `json:"acknowledgements"`
+	LastStatusChangeAt   string      `json:"last_status_change_at"`
+	LastStatusChangeBy   Reference   `json:"last_status_change_by"`
+	FirstTriggerLogEntry LogEntry    `json:"first_trigger_log_entry"`
+	Teams                []Reference `json:"teams"`
+	PendingActions       []struct {
+		Type string `json:"type"`
+		At   string `json:"at"`
+	} `json:"pending_actions"`
+}
+
+// IncidentList type, response of the list incidents endpoint
+type IncidentList struct {
+	Incidents []Incident `json:"incidents"`
+	Limit     int        `json:"limit"`
+	Offset    int        `json:"offset"`
+	Total     int        `json:"total"`
+	More      bool       `json:"more"`
+}
+
+// ListIncidentsOptions filters and paginates the incidents listed
+type ListIncidentsOptions struct {
+	UserIDs  []string
+	Statuses []string
+	Includes []string
+	Limit    int
+	Offset   int
+}
+
+func (options ListIncidentsOptions) query() url.Values {
+	query := url.Values{}
+	for _, userID := range options.UserIDs {
+		query.Add("user_ids[]", userID)
+	}
+	if options.Limit > 0 {
+		query.Add("limit", strconv.Itoa(options.Limit))
+	}
+	query.Add("offset", strconv.Itoa(options.Offset))
+	for _, status := range options.Statuses {
+		query.Add("statuses[]", status)
+	}
+	for _, include := range options.Includes {
+		query.Add("include[]", include)
+	}
+	return query
+}
+
+// ListIncidents returns one page of the incidents
+func (client *Client) ListIncidents(ctx context.Context, options ListIncidentsOptions) (list IncidentList, err error) {
+	err = client.do(ctx, "", http.MethodGet, "/incidents", options.query(), nil, &list)
+	return list, err
+}
+
+// GetIncident returns an incident with its first trigger log entry
+func (client *Client) GetIncident(ctx context.Context, id string) (Incident, error) {
+	var response struct {
+		Incident Incident `json:"incident"`
+	}
+	query := url.Values{}
+	query.Add("include[]", "first_trigger_log_entries")
+	err := client.do(ctx, "", http.MethodGet, "/incidents/"+id, query, nil, &response)
+	return response.Incident, err
+}
+
+// MaxBulkUpdate is the number of incidents PagerDuty updates at most in one
+// request
+const MaxBulkUpdate = 250
+
+// setStatus changes the status of an incident with the bulk update endpoint
+func (client *Client) setStatus(ctx context.Context, from string, id string, status string) error {
+	body := map[string]interface{}{
+		"incidents": []map[string]string{
+			{"id": id, "type": "incident_reference", "status": status},
+		},
+	}
+	return client.do(ctx, from, http.MethodPut, "/incidents", nil, body, nil)
+}
+
+// update changes the fields of a single incident
+func (client *Client) update(ctx context.Context, from string, id string, fields map[string]interface{}) error {
+	fields["type"] = "incident_reference"
+	return client.do(ctx, from, http.MethodPut, "/incidents/"+id, nil, map[string]interface{}{"incident": fields}, nil)
+}
+
+// Acknowledge acknowledges an incident
+func (client *Client) Acknowledge(ctx context.Context, from string, id string) error {
+	return client.setStatus(ctx, from, id, "acknowledged")
+}
+
+// AcknowledgeAll acknowledges up to MaxBulkUpdate incidents in one request.
+// The error of the request is returned when PagerDuty refused it as a whole,
+// otherwise results holds the error of every incident, nil for those
+// PagerDuty returned acknowledged
+func (client *Client) AcknowledgeAll(ctx context.Context, from string, ids []string) (results map[string]error, err error) {
+	if len(ids) > MaxBulkUpdate {
+		return nil, fmt.Errorf("%d incidents can not be updated at once, %d at most", len(ids), MaxBulkUpdate)
+	}
+	var references []map[string]string
+	for _, id := range ids {
+		references = append(references, map[string]string{"id": id, "type": "incident_reference", "status": "acknowledged"})
+	}
+	var response IncidentList
+	if err := client.do(ctx, from, http.MethodPut, "/incidents", nil, map[string]interface{}{"incidents": references}, &response); err != nil {
+		return nil, err
+	}
+	results = map[string]error{}
+	for _, id := range ids {
+		results[id] = errors.New("missing from the response of PagerDuty")
+	}
+	for _, incident := range response.Incidents {
+		if _, requested := results[incident.ID]; !requested {
+			continue
+		}
+		if incident.Status == "acknowledged" {
+			results[incident.ID] = nil
+		} else {
+			results[incident.ID] = fmt.Errorf("still %s", incident.Status)
+		}
+	}
+	return results, nil
+}
+
+// Resolve resolves an incident
+func (client *Client) Resolve(ctx context.Context, from string, id string) error {
+	return client.setStatus(ctx, from, id, "resolved")
+}
+
+// Snooze snoozes an acknowledged incident for duration, rounded down to the
+// second
+func (client *Client) Snooze(ctx context.Context, from string, id string, duration time.Duration) error {
+	body := map[string]int{"duration": int(duration.Seconds())}
+	return client.do(ctx, from, http.MethodPost, "/incidents/"+id+"/snooze", nil, body, nil)
+}
+
+// Reassign assigns an incident to a user, or to an escalation policy when
+// userID is empty
+func (client *Client) Reassign(ctx context.Context, from string, id string, userID string, escalationPolicyID string) error {
+	if userID != "" {
+		return client.update(ctx, from, id, map[string]interface{}{
+			"assignments": []interface{}{
+				map[string]interface{}{"assignee": map[string]string{"id": userID, "type": "user_reference"}},
+			},
+		})
+	}
+	return client.update(ctx, from, id, map[string]interface{}{
+		"escalation_policy": map[string]string{"id": escalationPolicyID, "type": "escalation_policy_reference"},
+	})
+}
+
+// SetUrgency changes the urgency of an incident, high or low
+func (client *Client) SetUrgency(ctx context.Context, from string, id string, urgency string) error {
+	return client.update(ctx, from, id, map[string]interface{}{"urgency": urgency})
+}
+
+// AddNote adds a note to an incident
+func (client *Client) AddNote(ctx context.Context, from string, id string, content string) error {
+	body := map[string]interface{}{"note": map[string]string{"content": content}}
+	return client.do(ctx, from, http.MethodPost, "/incidents/"+id+"/notes", nil, body, nil)
+}
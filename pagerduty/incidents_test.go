@@ -0,0 +1,90 @@
+package pagerduty
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListIncidents(t *testing.T) {
+	var query string
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte(`{"incidents":[{"id":"PO7FKW9","status":"triggered","first_trigger_log_entry":{"id":"R2X","channel":{"type":"api"}}}],"limit":100,"offset":200,"more":true}`))
+	})
+	defer server.Close()
+
+	list, err := client.ListIncidents(context.Background(), ListIncidentsOptions{
+		UserIDs:  []string{"XXXXXXX"},
+		Statuses: []string{"triggered", "acknowledged"},
+		Includes: []string{"first_trigger_log_entries"},
+		Limit:    100,
+		Offset:   200,
+	})
+	assert.Nil(t, err, "The incidents should be listed")
+	assert.Equal(t, query, "include%5B%5D=first_trigger_log_entries&limit=100&offset=200&statuses%5B%5D=triggered&statuses%5B%5D=acknowledged&user_ids%5B%5D=XXXXXXX", "Invalid query")
+	assert.Equal(t, len(list.Incidents), 1, "Invalid number of incidents")
+	assert.Equal(t, list.Incidents[0].FirstTriggerLogEntry.Channel.Type, "api", "The first trigger log entry should be decoded")
+	assert.True(t, list.More, "There should be more incidents")
+}
+
+func TestGetIncident(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/incidents/PO7FKW9", "Invalid path")
+		assert.Equal(t, r.URL.Query().Get("include[]"), "first_trigger_log_entries", "The first trigger log entry should be included")
+		w.Write([]byte(`{"incident":{"id":"PO7FKW9","status":"acknowledged"}}`))
+	})
+	defer server.Close()
+
+	incident, err := client.GetIncident(context.Background(), "PO7FKW9")
+	assert.Nil(t, err, "The incident should be fetched")
+	assert.Equal(t, incident.Status, "acknowledged", "Invalid status")
+}
+
+func TestIncidentUpdates(t *testing.T) {
+	var requests []string
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+readBody(r))
+		w.Write([]byte(`{}`))
+	})
+	defer server.Close()
+
+	assert.Nil(t, client.Acknowledge(context.Background(), "sebastien@lariviere.me", "PO7FKW9"), "The incident should be acknowledged")
+	assert.Nil(t, client.Resolve(context.Background(), "sebastien@lariviere.me", "PO7FKW9"), "The incident should be resolved")
+	assert.Nil(t, client.Snooze(context.Background(), "sebastien@lariviere.me", "PO7FKW9", time.Hour), "The incident should be snoozed")
+	assert.Nil(t, client.Reassign(context.Background(), "sebastien@lariviere.me", "PO7FKW9", "PXPGF42", ""), "The incident should be reassigned to the user")
+	assert.Nil(t, client.Reassign(context.Background(), "sebastien@lariviere.me", "PO7FKW9", "", "PT20YPA"), "The incident should be reassigned to the escalation policy")
+	assert.Nil(t, client.SetUrgency(context.Background(), "sebastien@lariviere.me", "PO7FKW9", "low"), "The urgency should be changed")
+	assert.Nil(t, client.AddNote(context.Background(), "sebastien@lariviere.me", "PO7FKW9", "Flapping"), "The note should be added")
+	assert.Equal(t, requests, []string{
+		`PUT /incidents {"incidents":[{"id":"PO7FKW9","status":"acknowledged","type":"incident_reference"}]}`,
+		`PUT /incidents {"incidents":[{"id":"PO7FKW9","status":"resolved","type":"incident_reference"}]}`,
+		`POST /incidents/PO7FKW9/snooze {"duration":3600}`,
+		`PUT /incidents/PO7FKW9 {"incident":{"assignments":[{"assignee":{"id":"PXPGF42","type":"user_reference"}}],"type":"incident_reference"}}`,
+		`PUT /incidents/PO7FKW9 {"incident":{"escalation_policy":{"id":"PT20YPA","type":"escalation_policy_reference"},"type":"incident_reference"}}`,
+		`PUT /incidents/PO7FKW9 {"incident":{"type":"incident_reference","urgency":"low"}}`,
+		`POST /incidents/PO7FKW9/notes {"note":{"content":"Flapping"}}`,
+	}, "Invalid requests")
+}
+
+func TestAcknowledgeAll(t *testing.T) {
+	var body string
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		body = readBody(r)
+		w.Write([]byte(`{"incidents":[{"id":"PO7FKW9","status":"acknowledged"},{"id":"PO7FKW0","status":"resolved"}]}`))
+	})
+	defer server.Close()
+
+	results, err := client.AcknowledgeAll(context.Background(), "sebastien@lariviere.me", []string{"PO7FKW9", "PO7FKW0", "PO7FKW1"})
+	assert.Nil(t, err, "The request should succeed")
+	assert.Equal(t, body, `{"incidents":[{"id":"PO7FKW9","status":"acknowledged","type":"incident_reference"},{"id":"PO7FKW0","status":"acknowledged","type":"incident_reference"},{"id":"PO7FKW1","status":"acknowledged","type":"incident_reference"}]}`, "Every incident should be sent in one request")
+	assert.Nil(t, results["PO7FKW9"], "The incident returned acknowledged should succeed")
+	assert.EqualError(t, results["PO7FKW0"], "still resolved", "The incident returned in another status should fail")
+	assert.EqualError(t, results["PO7FKW1"], "missing from the response of PagerDuty", "The incident missing from the response should fail")
+
+	_, err = client.AcknowledgeAll(context.Background(), "sebastien@lariviere.me", make([]string, MaxBulkUpdate+1))
+	assert.NotNil(t, err, "The number of incidents should be limited")
+}
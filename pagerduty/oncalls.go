@@ -0,0 +1,34 @@
+package pagerduty
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// OnCall type, an on-call shift of a user on an escalation policy
+type OnCall struct {
+	EscalationPolicy Reference `json:"escalation_policy"`
+	EscalationLevel  int       `json:"escalation_level"`
+	Schedule         Reference `json:"schedule"`
+	User             Reference `json:"user"`
+	Start            string    `json:"start"`
+	End              string    `json:"end"`
+}
+
+// OnCallList type, response of the list on-calls endpoint
+type OnCallList struct {
+	OnCalls []OnCall `json:"oncalls"`
+	Limit   int      `json:"limit"`
+	Offset  int      `json:"offset"`
+	More    bool     `json:"more"`
+}
+
+// ListOnCalls returns the current on-call shifts of a user
+func (client *Client) ListOnCalls(ctx context.Context, userID string) (list OnCallList, err error) {
+	query := url.Values{}
+	query.Add("user_ids[]", userID)
+	query.Add("limit", "100")
+	err = client.do(ctx, "", http.MethodGet, "/oncalls", query, nil, &list)
+	return list, err
+}
@@ -0,0 +1,22 @@
+package pagerduty
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListOnCalls(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.RawQuery, "limit=100&user_ids%5B%5D=XXXXXXX", "Invalid query")
+		w.Write([]byte(`{"oncalls":[{"escalation_policy":{"id":"P5W7JL2","type":"escalation_policy_reference"},"escalation_level":1,"schedule":{"id":"PI7DH85","summary":"Daily Engineering Rotation"}}],"limit":100,"offset":0,"more":false}`))
+	})
+	defer server.Close()
+
+	list, err := client.ListOnCalls(context.Background(), "XXXXXXX")
+	assert.Nil(t, err, "The on-call shifts should be listed")
+	assert.Equal(t, len(list.OnCalls), 1, "Invalid number of shifts")
+	assert.Equal(t, list.OnCalls[0].Schedule.Summary, "Daily Engineering Rotation", "Invalid schedule")
+}
@@ -0,0 +1,57 @@
+package pagerduty
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy decides whether and when a request failing on a temporary
+// error is sent again
+type RetryPolicy struct {
+	// MaxRetries is how many times a request is sent again at most
+	MaxRetries int
+	// InitialDelay is the delay before the first retry, doubled on every
+	// retry up to MaxDelay
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// DefaultRetryPolicy sends a request again 3 times, 1, 2 then 4 seconds
+// after it failed, give or take the jitter
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:   3,
+	InitialDelay: time.Second,
+	MaxDelay:     30 * time.Second,
+}
+
+// Backoff returns how long to wait before sending a request again after its
+// retry-th retry failed with err, the first attempt being retry 0, and false
+// when it should not be sent again. The delay grows exponentially with a
+// random jitter, so the requests failing together are not sent again
+// together, unless PagerDuty asked to wait for a given time. The request is
+// given up when PagerDuty asked to wait longer than MaxDelay
+func (policy RetryPolicy) Backoff(retry int, err error) (delay time.Duration, retryable bool) {
+	if retry >= policy.MaxRetries || !Temporary(err) {
+		return 0, false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		if apiErr.RetryAfter > policy.MaxDelay {
+			return 0, false
+		}
+		return apiErr.RetryAfter, true
+	}
+	delay = policy.InitialDelay
+	for i := 0; i < retry && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if delay <= 0 {
+		return 0, true
+	}
+	// Equal jitter, between half the delay and the delay
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)), true
+}
@@ -0,0 +1,50 @@
+package pagerduty
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 5, InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+	unavailable := &APIError{StatusCode: http.StatusServiceUnavailable}
+	for retry, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		delay, retryable := policy.Backoff(retry, unavailable)
+		assert.True(t, retryable, "Retry %d should be allowed", retry)
+		assert.True(t, delay >= expected/2 && delay <= expected, "Retry %d should wait about %s, not %s", retry, expected, delay)
+	}
+	_, retryable := policy.Backoff(5, unavailable)
+	assert.False(t, retryable, "The retries should be limited")
+	_, retryable = policy.Backoff(0, &APIError{StatusCode: http.StatusBadRequest})
+	assert.False(t, retryable, "A bad request should not be sent again")
+	_, retryable = policy.Backoff(0, errors.New("invalid character '<' looking for beginning of value"))
+	assert.False(t, retryable, "A response which can not be decoded should not be sent again")
+
+	delay, retryable := policy.Backoff(0, &APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: 3 * time.Second})
+	assert.True(t, retryable, "Rate limited requests should be sent again")
+	assert.Equal(t, delay, 3*time.Second, "Retry-After should be honored")
+	_, retryable = policy.Backoff(0, &APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour})
+	assert.False(t, retryable, "A Retry-After longer than MaxDelay should not be waited for")
+}
+
+func TestRetryAfter(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	defer server.Close()
+
+	err := client.do(context.Background(), "", http.MethodGet, "/incidents", nil, nil, nil)
+	if apiErr, ok := err.(*APIError); assert.True(t, ok, "The error should be an *APIError") {
+		assert.Equal(t, apiErr.RetryAfter, 7*time.Second, "Invalid Retry-After")
+		assert.True(t, apiErr.Temporary(), "Rate limited requests are temporary")
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	assert.True(t, parseRetryAfter(date) > 50*time.Second, "Retry-After can be a date")
+	assert.Equal(t, parseRetryAfter(""), time.Duration(0), "Retry-After is optional")
+}
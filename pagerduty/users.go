@@ -0,0 +1,28 @@
+package pagerduty
+
+import (
+	"context"
+	"net/http"
+)
+
+// User type, as returned by the get user endpoint
+type User struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// GetUser returns a user by ID
+func (client *Client) GetUser(ctx context.Context, id string) (User, error) {
+	var response struct {
+		User User `json:"user"`
+	}
+	err := client.do(ctx, "", http.MethodGet, "/users/"+id, nil, nil, &response)
+	return response.User, err
+}
+
+// GetCurrentUser returns the owner of the API key, only user API keys have
+// one
+func (client *Client) GetCurrentUser(ctx context.Context) (User, error) {
+	return client.GetUser(ctx, "me")
+}
@@ -0,0 +1,36 @@
+package pagerduty
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetUser(t *testing.T) {
+	var paths []string
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(`{"user":{"id":"PJGAQGT","name":"Sébastien Larivière","email":"sebastien@lariviere.me"}}`))
+	})
+	defer server.Close()
+
+	user, err := client.GetUser(context.Background(), "PJGAQGT")
+	assert.Nil(t, err, "The user should be fetched")
+	assert.Equal(t, user.Email, "sebastien@lariviere.me", "Invalid email")
+	_, err = client.GetCurrentUser(context.Background())
+	assert.Nil(t, err, "The owner of the API key should be fetched")
+	assert.Equal(t, paths, []string{"/users/PJGAQGT", "/users/me"}, "Invalid paths")
+}
+
+func TestGetUserNotFound(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"message":"Not Found","code":2100}}`))
+	})
+	defer server.Close()
+
+	_, err := client.GetUser(context.Background(), "PJGAQGT")
+	assert.Equal(t, err, &APIError{StatusCode: 404, Code: 2100, Message: "Not Found"}, "The user should not be found")
+}
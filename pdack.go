@@ -1,18 +1,18 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
-	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/BurntSushi/toml"
+
+	"github.com/slariviere/pdack/pagerduty"
 )
 
 // PagerDutyConfig contains all the PagerDuty required information
@@ -21,225 +21,477 @@ type PagerDutyConfig struct {
 	UserID       string
 	Account      string
 	RefreshDelay int
+	PageSize     int
+	MaxPages     int
+	Rules        []Rule `toml:"rule"`
+	Name         string
+	Targets      []TargetConfig `toml:"target"`
+	Windows      []Window       `toml:"window"`
+	StateFile    string
+	// targets are the [[target]] entries completed with the top level
+	// values by readTargets
+	targets []PagerDutyConfig
+
+	APIKeyFile    string
+	APIKeyCommand string
+
+	ConnectTimeout int
+	RequestTimeout int
+	MaxRetries     int
+	RetryDelay     string
+	RetryMaxDelay  string
+
+	RequestsPerMinute int
+
+	AuditFile       string
+	AuditMaxSize    int
+	AuditMaxBackups int
+
+	ListenAddress  string
+	WebhookSecret  string
+	WebhookPath    string
+	ReconcileDelay int
+
+	OnCallOnly               bool
+	OnCallSchedules          []string
+	OnCallEscalationPolicies []string
 }
 
 // PagerDutyConfigKeys contains all the keys of PagerDutyConfig
 var PagerDutyConfigKeys = []string{
 	"apiKey",
 	"userID",
-	"account",
 	"refreshDelay",
 }
 
 var filename = flag.String("conf", "pdack.conf", "Configuration file")
-var maxPDretries = 3
-var pdRetryCount = 0
-var waitDelay = 1
+var dryRun = flag.Bool("dry-run", false, "Log the action taken on every incident without sending it to PagerDuty")
 var config PagerDutyConfig
+var defaultPageSize = 100
+var defaultMaxPages = 10
+var defaultConnectTimeout = 10
+var defaultRequestTimeout = 30
+var defaultMaxRetries = 3
+var defaultRetryDelay = "1s"
+var defaultRetryMaxDelay = "30s"
 
 var myPrivateExitFunction = os.Exit
 
-// Incident type
-type Incident struct {
-	Incidents []struct {
-		Acknowledgers []struct {
-			At     string `json:"at"`
-			Object struct {
-				Email   string `json:"email"`
-				HTMLURL string `json:"html_url"`
-				ID      string `json:"id"`
-				Name    string `json:"name"`
-				Type    string `json:"type"`
-			} `json:"object"`
-		} `json:"acknowledgers"`
-		AssignedTo []struct {
-			At     string `json:"at"`
-			Object struct {
-				Email   string `json:"email"`
-				HTMLURL string `json:"html_url"`
-				ID      string `json:"id"`
-				Name    string `json:"name"`
-				Type    string `json:"type"`
-			} `json:"object"`
-		} `json:"assigned_to"`
-		AssignedToUser struct {
-			Email   string `json:"email"`
-			HTMLURL string `json:"html_url"`
-			ID      string `json:"id"`
-			Name    string `json:"name"`
-		} `json:"assigned_to_user"`
-		CreatedOn        string `json:"created_on"`
-		EscalationPolicy struct {
-			DeletedAt interface{} `json:"deleted_at"`
-			ID        string      `json:"id"`
-			Name      string      `json:"name"`
-		} `json:"escalation_policy"`
-		HTMLURL            string `json:"html_url"`
-		ID                 string `json:"id"`
-		IncidentKey        string `json:"incident_key"`
-		IncidentNumber     int    `json:"incident_number"`
-		LastStatusChangeBy struct {
-			Email   string `json:"email"`
-			HTMLURL string `json:"html_url"`
-			ID      string `json:"id"`
-			Name    string `json:"name"`
-		} `json:"last_status_change_by"`
-		LastStatusChangeOn  string        `json:"last_status_change_on"`
-		NumberOfEscalations int           `json:"number_of_escalations"`
-		PendingActions      []interface{} `json:"pending_actions"`
-		Service             struct {
-			DeletedAt   interface{} `json:"deleted_at"`
-			Description string      `json:"description"`
-			HTMLURL     string      `json:"html_url"`
-			ID          string      `json:"id"`
-			Name        string      `json:"name"`
-		} `json:"service"`
-		Status                string `json:"status"`
-		TriggerDetailsHTMLURL string `json:"trigger_details_html_url"`
-		TriggerSummaryData    struct {
-			Subject string `json:"subject"`
-		} `json:"trigger_summary_data"`
-		TriggerType string `json:"trigger_type"`
-		Urgency     string `json:"urgency"`
-	} `json:"incidents"`
-	Limit  int `json:"limit"`
-	Offset int `json:"offset"`
-	Total  int `json:"total"`
-}
+// The types of the API are those of the pagerduty package
+type (
+	Reference    = pagerduty.Reference
+	LogEntry     = pagerduty.LogEntry
+	Incident     = pagerduty.Incident
+	IncidentList = pagerduty.IncidentList
+)
 
+// readConfigFile reads and validates the configuration file, logging every
+// problem found
 func readConfigFile(configFileName string) (md toml.MetaData, success bool) {
+	config = PagerDutyConfig{}
 	md, err := toml.DecodeFile(configFileName, &config)
 	if err != nil {
 		log.Printf("An error occured while reading the configuation file: %s", err)
 		return md, false
 	}
-	if len(md.Keys()) < 3 {
-		for _, key := range PagerDutyConfigKeys {
-			if !md.IsDefined(key) {
-				log.Printf("An error occured while reading the configuation file, %s key is missing", key)
-			}
-		}
-		return md, false
+	overridden, success := applyOverrides()
+	config.keepOverriddenAPIKey(overridden)
+	if err := config.resolveAPIKey(); err != nil {
+		log.Printf("An error occured while reading the configuation file, %s", err)
+		success = false
 	}
-	return md, true
+	var undecoded []string
+	for _, key := range md.Undecoded() {
+		undecoded = append(undecoded, key.String())
+	}
+	if !checkUndecoded(undecoded) {
+		success = false
+	}
+	warnAccount()
+	if !compileWindows() {
+		success = false
+	}
+	// The top level rules are checked even when every target defines its own
+	if !compileRules(config.Rules) {
+		success = false
+	}
+	if len(config.Targets) > 0 {
+		return md, readTargets() && success
+	}
+	for _, problem := range config.configProblems() {
+		log.Printf("An error occured while reading the configuation file, %s", problem)
+		success = false
+	}
+	return md, success
 }
 
 func getConfigFile() (md toml.MetaData, success bool) {
-	pwd, _ := os.Getwd()
 	flag.Parse()
-	return readConfigFile(pwd + "/" + *filename)
+	return readConfigFile(getConfigFilePath())
 }
 
-func getPDURL() (url string) {
-	return "https://" + config.Account + ".pagerduty.com"
+// getAuditMaxSize returns the size of the audit log rotating it, in bytes
+func (pdConfig PagerDutyConfig) getAuditMaxSize() int64 {
+	if pdConfig.AuditMaxSize > 0 {
+		return int64(pdConfig.AuditMaxSize) * 1024 * 1024
+	}
+	return int64(defaultAuditMaxSize) * 1024 * 1024
 }
 
-func buildURL(resource string, data url.Values) (builtURL string) {
-	u, _ := url.ParseRequestURI(getPDURL())
-	u.Path = resource
-	u.RawQuery = data.Encode()
-	return fmt.Sprintf("%v", u)
+func (pdConfig PagerDutyConfig) getAuditMaxBackups() int {
+	if pdConfig.AuditMaxBackups > 0 {
+		return pdConfig.AuditMaxBackups
+	}
+	return defaultAuditMaxBackups
 }
 
-func buidAcknowledgeURL(id string) (incidentURL string) {
-	return buildURL("/api/v1/incidents/"+id+"/acknowledge", url.Values{})
+func (target *Target) getPageSize() int {
+	if target.PageSize > 0 {
+		return target.PageSize
+	}
+	return defaultPageSize
 }
 
-func buidIcindentURL() (incidentURL string) {
-	data := url.Values{}
-	data.Add("assigned_to_user", config.UserID)
-	return buildURL("/api/v1/incidents", data)
+func (target *Target) getMaxPages() int {
+	if target.MaxPages > 0 {
+		return target.MaxPages
+	}
+	return defaultMaxPages
 }
 
-func acknowledgeIncicent(id string) (success bool) {
-	urlStr := buidAcknowledgeURL(id)
-	req, err := http.NewRequest("PUT", urlStr, strings.NewReader(`{"requester_id": "`+config.UserID+`"}`))
-	req.Header.Set("Content-type", "application/json")
-	req.Header.Add("Authorization", "Token token="+config.APIKey)
+// getConnectTimeout returns how long to wait for the connection to
+// PagerDuty to be established
+func (pdConfig PagerDutyConfig) getConnectTimeout() time.Duration {
+	if pdConfig.ConnectTimeout > 0 {
+		return time.Duration(pdConfig.ConnectTimeout) * time.Second
+	}
+	return time.Duration(defaultConnectTimeout) * time.Second
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		panic(err)
+// getRequestTimeout returns how long to wait for PagerDuty to answer a
+// request, connection included
+func (pdConfig PagerDutyConfig) getRequestTimeout() time.Duration {
+	if pdConfig.RequestTimeout > 0 {
+		return time.Duration(pdConfig.RequestTimeout) * time.Second
+	}
+	return time.Duration(defaultRequestTimeout) * time.Second
+}
+
+func (pdConfig PagerDutyConfig) getRequestsPerMinute() int {
+	if pdConfig.RequestsPerMinute > 0 {
+		return pdConfig.RequestsPerMinute
+	}
+	return defaultRequestsPerMinute
+}
+
+// getRetryPolicy returns how the requests failing on a temporary error are
+// sent again, the durations being validated with the configuration
+func (pdConfig PagerDutyConfig) getRetryPolicy() pagerduty.RetryPolicy {
+	policy := pagerduty.RetryPolicy{MaxRetries: defaultMaxRetries}
+	if pdConfig.MaxRetries > 0 {
+		policy.MaxRetries = pdConfig.MaxRetries
+	}
+	retryDelay, retryMaxDelay := pdConfig.RetryDelay, pdConfig.RetryMaxDelay
+	if retryDelay == "" {
+		retryDelay = defaultRetryDelay
+	}
+	if retryMaxDelay == "" {
+		retryMaxDelay = defaultRetryMaxDelay
+	}
+	policy.InitialDelay, _ = time.ParseDuration(retryDelay)
+	policy.MaxDelay, _ = time.ParseDuration(retryMaxDelay)
+	return policy
+}
+
+// getPDUserEmail returns the email of the configured user, required by the
+// API v2 in the From header of any write request
+func (target *Target) getPDUserEmail() (email string, success bool) {
+	if target.pdUserEmail != "" {
+		return target.pdUserEmail, true
+	}
+	var user pagerduty.User
+	success = target.request("get the user "+target.UserID+" from PagerDuty", func(ctx context.Context) (err error) {
+		user, err = target.client.GetUser(ctx, target.UserID)
+		return err
+	})
+	if !success {
+		return "", false
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == 200 {
-		return true
+	target.pdUserEmail = user.Email
+	return target.pdUserEmail, target.pdUserEmail != ""
+}
+
+// request calls PagerDuty until it succeeds or the retry policy of the
+// target gives up. Every call is given requestTimeout seconds. Once the
+// target is asked to stop, the call in flight finishes but is not sent again.
+// The action in progress is audited with the attempts of its last request,
+// the acknowledgement preceding a snooze is not counted
+func (target *Target) request(description string, call func(ctx context.Context) error) (success bool) {
+	policy := target.getRetryPolicy()
+	if target.auditing != nil {
+		target.auditing.Attempt = 0
 	}
-	if resp.StatusCode == 408 || resp.StatusCode == 500 {
-		// There was a recoverable error, retrying in $waitDelay second
-		time.Sleep(time.Duration(waitDelay) * time.Second)
-		if pdRetryCount < maxPDretries {
-			pdRetryCount++
-			return acknowledgeIncicent(id)
+	for retry := 0; ; retry++ {
+		if !target.waitForRateLimit() {
+			target.logf("Unable to %s, stopping", description)
+			return false
+		}
+		if target.auditing != nil {
+			target.auditing.Attempt++
 		}
+		ctx, cancel := context.WithTimeout(context.Background(), target.getRequestTimeout())
+		err := call(ctx)
+		cancel()
+		if err == nil {
+			return true
+		}
+		delay, retryable := policy.Backoff(retry, err)
+		if !retryable || target.stopRequested() {
+			target.logf("Unable to %s, %s", description, err)
+			return false
+		}
+		target.logf("Unable to %s, %s, retrying in %s", description, err, delay)
+		if target.auditing != nil {
+			event := *target.auditing
+			event.Event = auditRetry
+			event.Time = now()
+			target.writeAudit(event)
+		}
+		target.countRetry()
+		if !target.sleep(delay) {
+			target.logf("Unable to %s, stopping", description)
+			return false
+		}
+	}
+}
+
+// sendPDUpdate sends a write request to PagerDuty on behalf of the user,
+// whose email is given to update as from, retrying on recoverable errors
+func (target *Target) sendPDUpdate(description string, update func(ctx context.Context, from string) error) (success bool) {
+	email, success := target.getPDUserEmail()
+	if !success {
 		return false
 	}
-	return false
+	return target.request(description, func(ctx context.Context) error {
+		return update(ctx, email)
+	})
+}
+
+func (target *Target) acknowledgeIncicent(id string) (success bool) {
+	return target.sendPDUpdate("acknowledge the incident "+id, func(ctx context.Context, from string) error {
+		return target.client.Acknowledge(ctx, from, id)
+	})
+}
+
+// getPDIncidentsPage fetches one page of the incidents assigned to the user,
+// starting at offset
+func (target *Target) getPDIncidentsPage(offset int) (page IncidentList, success bool) {
+	success = target.request("list the incidents of "+target.UserID, func(ctx context.Context) (err error) {
+		page, err = target.client.ListIncidents(ctx, pagerduty.ListIncidentsOptions{
+			UserIDs:  []string{target.UserID},
+			Statuses: []string{"triggered", "acknowledged"},
+			Includes: []string{"first_trigger_log_entries"},
+			Limit:    target.getPageSize(),
+			Offset:   offset,
+		})
+		return err
+	})
+	return page, success
 }
 
-func getAssignedPDIncidents() (success bool) {
+// handleTriggeredIncident acts on a triggered incident according to the
+// rules of the target, or audits why it has been skipped. An action which
+// failed on the incident waits to be tried again, as in a poll
+func (target *Target) handleTriggeredIncident(incident Incident) (acted bool, success bool) {
+	rule, act := target.selectRule(incident)
+	if !act {
+		return false, true
+	}
+	if target.postponed(incident) {
+		target.logf("Incident %s (%s) has been postponed, its last action failed\n", incident.Title, incident.ID)
+		return false, true
+	}
+	if !target.performAction(rule, incident) {
+		return false, false
+	}
+	return true, true
+}
+
+// selectRule returns the rule to apply to a triggered incident, or audits
+// why it is skipped
+func (target *Target) selectRule(incident Incident) (rule *Rule, act bool) {
+	rule, act = target.matchRules(incident)
+	if !act {
+		event := target.newAuditEvent(auditSkip, incident, rule)
+		if rule != nil {
+			event.Reason = "excluded by rule"
+			target.logf("Incident %s (%s) has been skipped, excluded by rule %s\n", incident.Title, incident.ID, rule.Name)
+		} else {
+			event.Reason = "no rule matched"
+			target.logf("Incident %s (%s) has been skipped, no rule matched\n", incident.Title, incident.ID)
+		}
+		if !*dryRun {
+			target.writeAudit(event)
+		}
+	}
+	return rule, act
+}
+
+func (target *Target) getAssignedPDIncidents() (success bool) {
+	defer func(start time.Time) { target.recordPoll(start, success) }(time.Now())
+	target.mutex.Lock()
+	defer target.mutex.Unlock()
+	active, success := target.checkOnCall()
+	if !success || !active {
+		return success
+	}
 
 	nbTriggered := 0
 	nbAcknowledged := 0
-	urlStr := buidIcindentURL()
-	req, err := http.NewRequest("GET", urlStr, nil)
-	req.Header.Set("Content-type", "application/json")
-	req.Header.Add("Authorization", "Token token="+config.APIKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		panic(err)
+	nbSkipped := 0
+	nbActed := 0
+	nbFailed := 0
+	nbPostponed := 0
+	var incidents []Incident
+	offset := 0
+	for nbPages := 0; ; nbPages++ {
+		if nbPages == target.getMaxPages() {
+			target.logf("Stopped after %d pages, the remaining incidents will be fetched on the next refresh", nbPages)
+			break
+		}
+		if nbPages > 0 && target.stopRequested() {
+			return false
+		}
+		page, success := target.getPDIncidentsPage(offset)
+		if !success {
+			return false
+		}
+		incidents = append(incidents, page.Incidents...)
+		if !page.More || len(page.Incidents) == 0 {
+			break
+		}
+		offset += len(page.Incidents)
 	}
-	defer resp.Body.Close()
 
-	body, _ := ioutil.ReadAll(resp.Body)
-	var incidentResponse Incident
-	json.Unmarshal(body, &incidentResponse)
-	log.Printf("%d incident found", incidentResponse.Total)
-	for _, curentIncident := range incidentResponse.Incidents {
+	target.logf("%d incident found", len(incidents))
+	defer target.saveState()
+	target.pruneOccurrences()
+	for _, curentIncident := range incidents {
+		target.recordOccurrence(curentIncident)
+		state.observe(target, curentIncident)
+	}
+	target.forgetFailedActions(incidents)
+	// The incidents to acknowledge are acknowledged in bulk once all of them
+	// are known
+	var acks []matchedIncident
+	for _, curentIncident := range incidents {
+		// PagerDuty refuses every request with the API key from now on, or
+		// the remaining incidents are left to the next start
+		if target.fatal != 0 || target.stopRequested() {
+			return false
+		}
 		if curentIncident.Status == "triggered" {
 			nbTriggered++
-			if acknowledgeIncicent(curentIncident.ID) {
-				log.Printf("Incident %s (%s) has been Acknowledged\n", curentIncident.TriggerSummaryData.Subject, curentIncident.ID)
+			rule, act := target.selectRule(curentIncident)
+			if !act {
+				nbSkipped++
+				continue
+			}
+			if target.postponed(curentIncident) {
+				nbPostponed++
+				continue
+			}
+			if ruleAction(rule) == actionAcknowledge && !*dryRun {
+				acks = append(acks, matchedIncident{rule, curentIncident})
+				continue
+			}
+			if target.performAction(rule, curentIncident) {
+				nbActed++
 			} else {
-				return false
+				nbFailed++
 			}
 		} else if curentIncident.Status == "acknowledged" {
 			nbAcknowledged++
 		}
 	}
-	log.Printf("%d acknowledged, %d triggered", nbAcknowledged, nbTriggered)
-	if resp.StatusCode == 200 {
-		pdRetryCount = 0
-		return true
+	nbAckFailed := target.acknowledgeIncidents(acks)
+	nbActed += len(acks) - nbAckFailed
+	nbFailed += nbAckFailed
+	if nbFailed > 0 || nbPostponed > 0 {
+		target.logf("%d actions failed and %d wait to be tried again, they will be on a later refresh", nbFailed, nbPostponed)
 	}
-	if resp.StatusCode == 408 || resp.StatusCode == 500 {
-		// There was a recoverable error, retrying in $waitDelay second
-		time.Sleep(time.Duration(waitDelay) * time.Second)
-		if pdRetryCount < maxPDretries {
-			pdRetryCount++
-			return getAssignedPDIncidents()
-		}
-		return false
+	metrics.set("pdack_incidents", metricLabels("target", target.metricLabel(), "status", "triggered"), float64(nbTriggered))
+	metrics.set("pdack_incidents", metricLabels("target", target.metricLabel(), "status", "acknowledged"), float64(nbAcknowledged))
+	if *dryRun {
+		target.logf("%d acknowledged, %d triggered, %d would be acted upon, %d skipped", nbAcknowledged, nbTriggered, nbActed, nbSkipped)
+	} else {
+		target.logf("%d acknowledged, %d triggered, %d skipped", nbAcknowledged, nbTriggered, nbSkipped)
 	}
-	return false
+	return target.fatal == 0
 }
 
 func main() {
-	_, success := getConfigFile()
+	flag.Parse()
+	if flag.NArg() > 0 {
+		myPrivateExitFunction(runCommand(flag.Args()))
+		return
+	}
+	md, success := getConfigFile()
+	success = success && checkConfigPermissions(getConfigFilePath(), md)
 
-	if success {
-		for true {
-			if !getAssignedPDIncidents() {
-				myPrivateExitFunction(1)
-				return
-			}
-			time.Sleep(time.Duration(config.RefreshDelay) * time.Second)
+	if success && config.StateFile != "" {
+		var err error
+		if state, err = loadState(config.StateFile); err != nil {
+			log.Printf("An error occured while reading the state file: %s", err)
+			success = false
 		}
-	} else {
-		myPrivateExitFunction(1)
+	}
+	if success && config.AuditFile != "" {
+		var err error
+		if audit, err = openAuditLog(config.AuditFile, config.getAuditMaxSize(), config.getAuditMaxBackups()); err != nil {
+			log.Printf("An error occured while opening the audit log: %s", err)
+			success = false
+		}
+		defer func() { audit.close() }()
+	}
+	success = success && checkMode()
+	targets := getTargets()
+	var server *http.Server
+	var receiver *WebhookReceiver
+	if success && config.ListenAddress != "" {
+		mux := newServeMux()
+		if *mode == modeWebhook {
+			receiver = newWebhookReceiver(config.WebhookSecret, targets)
+			mux.Handle(config.getWebhookPath(), receiver)
+		}
+		server, success = startHTTPServer(config.ListenAddress, mux)
+	}
+	if !success {
+		myPrivateExitFunction(exitInvalidConfig)
 		return
 	}
+
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	// Every target is polled on its own, pdack stops once all of them met an
+	// unrecoverable error
+	poller := startPolling(targets)
+	for {
+		select {
+		case <-poller.done:
+			shutdown(server, receiver)
+			myPrivateExitFunction(poller.exitCode())
+			return
+		case received := <-signals:
+			if received == syscall.SIGHUP {
+				poller = reload(poller, receiver)
+				continue
+			}
+			log.Printf("Received %s, stopping once the requests in flight are done", received)
+			poller.stopPolling()
+			shutdown(server, receiver)
+			myPrivateExitFunction(exitOK)
+			return
+		}
+	}
 }
@@ -3,10 +3,16 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"gopkg.in/h2non/gock.v0"
 
@@ -21,10 +27,10 @@ var TestFiles = []struct {
 }{
 	{"/pdack_sample.conf", true, []string{""}},
 	{"/_example/invalid_filename.conf", false, []string{"_example/invalid_filename.conf"}},
-	{"/_example/empty.conf", false, []string{"apiKey", "userID", "account"}},
+	{"/_example/empty.conf", false, []string{"apiKey", "userID", "refreshDelay"}},
 	{"/_example/missing_email.conf", false, []string{"userID"}},
 	{"/_example/missing_apiKey.conf", false, []string{"apiKey"}},
-	{"/_example/missing_account.conf", false, []string{"account"}},
+	{"/_example/missing_account.conf", true, []string{""}},
 }
 
 var b bytes.Buffer
@@ -33,15 +39,44 @@ var testExitCode = 0
 
 func init() {
 	log.SetOutput(traceBuffer)
-	waitDelay = 0
+	defaultRetryDelay = "0s"
+	// The tests send their requests back to back
+	defaultRequestsPerMinute = 60000
+	// gock only intercepts the requests sent with the default transport
+	newHTTPClient = func(time.Duration) *http.Client {
+		return &http.Client{}
+	}
 	// Desactivate the os.Exit duing the tests
 	myPrivateExitFunction = func(c int) {
 		testExitCode = c
 	}
+	// Every test starts from the sample configuration
+	readConfigFile("pdack_sample.conf")
+}
+
+// privateCopy copies a configuration file holding an API key into a
+// temporary directory, only readable by its owner as pdack requires
+func privateCopy(t *testing.T, path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := filepath.Join(t.TempDir(), filepath.Base(path))
+	writeTestConfig(t, copied, string(content))
+	return copied
+}
+
+// newTestTarget returns a target watching the user of the configuration
+func newTestTarget() *Target {
+	target := newTarget(config)
+	// Avoid looking up the user's email before every acknowledgement
+	target.pdUserEmail = "sebastien@lariviere.me"
+	return target
 }
 
 // TestReadConfigFile tests the configuation file is being read correctly
 func TestReadConfigFile(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
 	pwd, _ := os.Getwd()
 	for _, testFile := range TestFiles {
 		_, res := readConfigFile(pwd + testFile.filename)
@@ -68,6 +103,7 @@ func TestReadConfigFile(t *testing.T) {
 
 // TestgetConfigFile tests reading the default value of GetConfigFile
 func TestGetConfigFileDefault(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
 	var md toml.MetaData
 	_, returnedmd := getConfigFile()
 	assert.NotEqual(t, md, returnedmd, "The config from the PagerDutyConfig should not be empty")
@@ -75,9 +111,10 @@ func TestGetConfigFileDefault(t *testing.T) {
 
 // TestgetConfigFile tests reading the conf argument
 func TestGetConfigFile(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
 	var md toml.MetaData
 	for _, testFile := range TestFiles {
-		os.Args = []string{os.Args[0], "--conf=" + testFile.filename}
+		os.Args = []string{os.Args[0], "--conf=" + strings.TrimPrefix(testFile.filename, "/")}
 		returnedmd, res := getConfigFile()
 		if res != testFile.passing {
 			if testFile.passing {
@@ -89,200 +126,532 @@ func TestGetConfigFile(t *testing.T) {
 	}
 }
 
-func TestGetPDURL(t *testing.T) {
-	assert.Equal(t, getPDURL(), "https://"+config.Account+".pagerduty.com", "Invalid url returned by getPDURL")
-}
+func TestGetPDUserEmail(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	target.pdUserEmail = ""
 
-func TestBuidIcindentURL(t *testing.T) {
-	assert.Equal(t, buidIcindentURL(), "https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user=XXXXXXX", "Invalid url returned by buidIcindentURL")
+	gock.New("https://api.pagerduty.com/users/"+config.UserID).
+		MatchHeader("Authorization", config.APIKey).
+		MatchHeader("Accept", "version=2").
+		Reply(200).
+		BodyString(`{"user":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me"}}`)
+
+	email, res := target.getPDUserEmail()
+	assert.Equal(t, res, true, "Response code is 200, getPDUserEmail should return true")
+	assert.Equal(t, email, "sebastien@lariviere.me", "Invalid email returned by getPDUserEmail")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
 }
 
-func TestBuidAcknowledgeURL(t *testing.T) {
-	assert.Equal(t, buidAcknowledgeURL("123"), "https://"+config.Account+".pagerduty.com/api/v1/incidents/123/acknowledge", "Invalid url returned by buidAcknowledgeURL")
+func TestGetPDUserEmailNotFound(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	target.pdUserEmail = ""
+
+	gock.New("https://api.pagerduty.com/users/" + config.UserID).
+		Reply(404).
+		BodyString(`{"error":{"message":"Not Found","code":2100}}`)
+
+	_, res := target.getPDUserEmail()
+	assert.Equal(t, res, false, "Response code is 404, getPDUserEmail should return false")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
 }
 
 func TestGetAssignedPDIncidents(t *testing.T) {
 	defer gock.Off()
+	target := newTestTarget()
 
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user="+config.UserID).
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
 		MatchHeader("Authorization", config.APIKey).
 		Reply(200).
-		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":0}`)
+		BodyString(`{"incidents":[],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	assert.Equal(t, getAssignedPDIncidents(), true, "Response code is 200, getAssignedPDIncidents should return true")
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Response code is 200, getAssignedPDIncidents should return true")
 
 	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
 }
 
 func TestGetAssignedPDIncidentsBadRequest(t *testing.T) {
 	defer gock.Off()
+	target := newTestTarget()
 
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user="+config.UserID).
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
 		MatchHeader("Authorization", config.APIKey).
 		Reply(400).
-		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":0}`)
+		BodyString(`{"incidents":[],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	assert.Equal(t, getAssignedPDIncidents(), false, "Response code is 400, getAssignedPDIncidents should return false")
+	assert.Equal(t, target.getAssignedPDIncidents(), false, "Response code is 400, getAssignedPDIncidents should return false")
 
 	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
 }
 
+func TestGetAssignedPDIncidentsErrorPage(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
+		MatchHeader("Authorization", config.APIKey).
+		Reply(200).
+		SetHeader("Content-Type", "text/html").
+		BodyString(`<html><body><h1>Maintenance</h1></body></html>`)
+
+	traceBuffer.Flush()
+	b.Reset()
+	assert.Equal(t, target.getAssignedPDIncidents(), false, "A response which does not decode should fail the poll")
+	traceBuffer.Flush()
+	assert.Contains(t, b.String(), "unable to decode the response with status code 200", "The error should be logged")
+	assert.NotContains(t, b.String(), "0 incident found", "No incident should be reported")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
 func TestGetAssignedPDIncidentsRetriesFails(t *testing.T) {
-	pdRetryCount = 0
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user="+config.UserID).
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
 		MatchHeader("Authorization", config.APIKey).
 		Reply(500).
-		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":0}`)
+		BodyString(`{"incidents":[],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user="+config.UserID).
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
 		MatchHeader("Authorization", config.APIKey).
 		Reply(500).
-		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":0}`)
+		BodyString(`{"incidents":[],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user="+config.UserID).
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
 		MatchHeader("Authorization", config.APIKey).
 		Reply(500).
-		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":0}`)
+		BodyString(`{"incidents":[],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user="+config.UserID).
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
 		MatchHeader("Authorization", config.APIKey).
 		Reply(500).
-		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":0}`)
+		BodyString(`{"incidents":[],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	assert.Equal(t, getAssignedPDIncidents(), false, "Response code is 500 too many times, getAssignedPDIncidents should return false")
+	assert.Equal(t, target.getAssignedPDIncidents(), false, "Response code is 500 too many times, getAssignedPDIncidents should return false")
 	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
 }
 
 func TestGetAssignedPDIncidentsRetriesSucceed(t *testing.T) {
-	pdRetryCount = 0
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user="+config.UserID).
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
 		MatchHeader("Authorization", config.APIKey).
 		Reply(500).
-		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":0}`)
+		BodyString(`{"incidents":[],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user="+config.UserID).
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
 		MatchHeader("Authorization", config.APIKey).
 		Reply(500).
-		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":0}`)
+		BodyString(`{"incidents":[],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user="+config.UserID).
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
 		MatchHeader("Authorization", config.APIKey).
 		Reply(500).
-		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":0}`)
+		BodyString(`{"incidents":[],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user="+config.UserID).
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
 		MatchHeader("Authorization", config.APIKey).
 		Reply(200).
-		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":0}`)
+		BodyString(`{"incidents":[],"limit":25,"offset":0,"total":null,"more":false}`)
+
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Response code is 200 at the last moment, getAssignedPDIncidents should return true")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+func TestGetAssignedPDIncidentsTransportErrors(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=" + config.UserID).
+		ReplyError(errors.New("connection reset by peer"))
+
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=" + config.UserID).
+		Reply(200).
+		BodyString(`{"incidents":[],"limit":25,"offset":0,"total":null,"more":false}`)
+
+	b.Reset()
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "A transport error should be retried")
+	traceBuffer.Flush()
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+	assert.True(t, strings.Contains(b.String(), "connection reset by peer, retrying"), "Expected the transport error in the logs: %s", b.String())
+}
+
+func TestGetAssignedPDIncidentsRetryPolicy(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	target.MaxRetries = 1
+	for _, status := range []int{429, 503, 200} {
+		gock.New("https://api.pagerduty.com/incidents?user_ids[]=" + config.UserID).
+			Reply(status).
+			BodyString(`{"incidents":[],"limit":25,"offset":0,"total":null,"more":false}`)
+	}
 
-	assert.Equal(t, getAssignedPDIncidents(), true, "Response code is 200 at the last moment, getAssignedPDIncidents should return true")
+	assert.Equal(t, target.getAssignedPDIncidents(), false, "The target should give up after one retry")
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Rate limited and unavailable responses should be retried")
 	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
 }
 
 func TestGetAssignedPDIncidentsWithAck(t *testing.T) {
-	pdRetryCount = 0
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user="+config.UserID).
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
 		MatchHeader("Authorization", config.APIKey).
 		Reply(200).
-		BodyString(`{"incidents":[{"id":"PO7FKW9","incident_number":111661,"created_on":"2016-04-03T01:54:02Z","status":"triggered","pending_actions":[],"html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","name":"TEST_SERVICE","html_url":"https://your_account.pagerduty.com/services/P7C31P0","deleted_at":null,"description":""},"escalation_policy":{"id":"P5W7JL2","name":"MO - Sebastien Lariviere","deleted_at":null},"assigned_to_user":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"},"trigger_summary_data":{"subject":"t"},"trigger_details_html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","trigger_type":"web_trigger","last_status_change_on":"2016-04-03T01:54:02Z","last_status_change_by":null,"number_of_escalations":0,"assigned_to":[{"at":"2016-04-03T01:54:02Z","object":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me","html_url":"https://your_account.pagerduty.com/users/PJGAQGT","type":"user"}}],"urgency":"low"}],"limit":100,"offset":0,"total":1}`)
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","summary":"[#111661] t","self":"https://api.pagerduty.com/incidents/PO7FKW9","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_number":111661,"title":"t","created_at":"2016-04-03T01:54:02Z","status":"triggered","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","type":"service_reference","summary":"TEST_SERVICE","self":"https://api.pagerduty.com/services/P7C31P0","html_url":"https://your_account.pagerduty.com/services/P7C31P0"},"escalation_policy":{"id":"P5W7JL2","type":"escalation_policy_reference","summary":"MO - Sebastien Lariviere","self":"https://api.pagerduty.com/escalation_policies/P5W7JL2","html_url":"https://your_account.pagerduty.com/escalation_policies/P5W7JL2"},"assignments":[{"at":"2016-04-03T01:54:02Z","assignee":{"id":"PJGAQGT","type":"user_reference","summary":"S\u00e9bastien Larivi\u00e8re","self":"https://api.pagerduty.com/users/PJGAQGT","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"}}],"acknowledgements":[],"last_status_change_at":"2016-04-03T01:54:02Z","last_status_change_by":null,"first_trigger_log_entry":{"id":"Q0P5VKOXNK4MSF","type":"trigger_log_entry","summary":"Triggered through the website","self":"https://api.pagerduty.com/log_entries/Q0P5VKOXNK4MSF","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","created_at":"2016-04-03T01:54:02Z","channel":{"type":"web_trigger"}},"teams":[],"urgency":"low","pending_actions":[]}],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents/PO7FKW9/acknowledge").
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9").
 		MatchHeader("Authorization", config.APIKey).
 		Reply(200).
-		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":0}`)
+		BodyString(`{"incidents":[],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	assert.Equal(t, getAssignedPDIncidents(), true, "Should send ack to the mentionned icident ID")
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Should send ack to the mentionned icident ID")
 	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
 }
 
 func TestGetAssignedPDIncidentsWithAcks(t *testing.T) {
-	pdRetryCount = 0
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user="+config.UserID).
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
 		MatchHeader("Authorization", config.APIKey).
 		Reply(200).
-		BodyString(`{ "incidents": [ { "id": "PO7FKW9", "incident_number": 111661, "created_on": "2016-04-03T01:54:02Z", "status": "triggered", "pending_actions": [], "html_url": "https://your_account.pagerduty.com/incidents/PO7FKW9", "incident_key": "66274f0746384df2ad51c04c2d4069bb", "service": { "id": "P7C31P0", "name": "TEST_SERVICE", "html_url": "https://your_account.pagerduty.com/services/P7C31P0", "deleted_at": null, "description": "" }, "escalation_policy": { "id": "P5W7JL2", "name": "MO - Sebastien Lariviere", "deleted_at": null }, "assigned_to_user": { "id": "PJGAQGT", "name": "Sébastien Larivière", "email": "sebastien@lariviere.me", "html_url": "https://your_account.pagerduty.com/users/PJGAQGT" }, "trigger_summary_data": { "subject": "t" }, "trigger_details_html_url": "https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF", "trigger_type": "web_trigger", "last_status_change_on": "2016-04-03T01:54:02Z", "last_status_change_by": null, "number_of_escalations": 0, "assigned_to": [ { "at": "2016-04-03T01:54:02Z", "object": { "id": "PJGAQGT", "name": "Sébastien Larivière", "email": "sebastien@lariviere.me", "html_url": "https://your_account.pagerduty.com/users/PJGAQGT", "type": "user" } } ], "urgency": "low" }, { "id": "PO7FKW0", "incident_number": 111661, "created_on": "2016-04-03T01:54:02Z", "status": "triggered", "pending_actions": [], "html_url": "https://your_account.pagerduty.com/incidents/PO7FKW0", "incident_key": "66274f0746384df2ad51c04c2d4069ba", "service": { "id": "P7C31P0", "name": "TEST_SERVICE", "html_url": "https://your_account.pagerduty.com/services/P7C31P0", "deleted_at": null, "description": "" }, "escalation_policy": { "id": "P5W7JL2", "name": "MO - Sebastien Lariviere", "deleted_at": null }, "assigned_to_user": { "id": "PJGAQGT", "name": "Sébastien Larivière", "email": "sebastien@lariviere.me", "html_url": "https://your_account.pagerduty.com/users/PJGAQGT" }, "trigger_summary_data": { "subject": "t2" }, "trigger_details_html_url": "https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF", "trigger_type": "web_trigger", "last_status_change_on": "2016-04-03T01:54:02Z", "last_status_change_by": null, "number_of_escalations": 0, "assigned_to": [ { "at": "2016-04-03T01:54:02Z", "object": { "id": "PJGAQGT", "name": "Sébastien Larivière", "email": "sebastien@lariviere.me", "html_url": "https://your_account.pagerduty.com/users/PJGAQGT", "type": "user" } } ], "urgency": "low" } ], "limit": 100, "offset": 0, "total": 1 }`)
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","summary":"[#111661] t","self":"https://api.pagerduty.com/incidents/PO7FKW9","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_number":111661,"title":"t","created_at":"2016-04-03T01:54:02Z","status":"triggered","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","type":"service_reference","summary":"TEST_SERVICE","self":"https://api.pagerduty.com/services/P7C31P0","html_url":"https://your_account.pagerduty.com/services/P7C31P0"},"escalation_policy":{"id":"P5W7JL2","type":"escalation_policy_reference","summary":"MO - Sebastien Lariviere","self":"https://api.pagerduty.com/escalation_policies/P5W7JL2","html_url":"https://your_account.pagerduty.com/escalation_policies/P5W7JL2"},"assignments":[{"at":"2016-04-03T01:54:02Z","assignee":{"id":"PJGAQGT","type":"user_reference","summary":"S\u00e9bastien Larivi\u00e8re","self":"https://api.pagerduty.com/users/PJGAQGT","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"}}],"acknowledgements":[],"last_status_change_at":"2016-04-03T01:54:02Z","last_status_change_by":null,"first_trigger_log_entry":{"id":"Q0P5VKOXNK4MSF","type":"trigger_log_entry","summary":"Triggered through the website","self":"https://api.pagerduty.com/log_entries/Q0P5VKOXNK4MSF","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","created_at":"2016-04-03T01:54:02Z","channel":{"type":"web_trigger"}},"teams":[],"urgency":"low","pending_actions":[]},{"id":"PO7FKW0","type":"incident","summary":"[#111661] t2","self":"https://api.pagerduty.com/incidents/PO7FKW0","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW0","incident_number":111661,"title":"t2","created_at":"2016-04-03T01:54:02Z","status":"triggered","incident_key":"66274f0746384df2ad51c04c2d4069ba","service":{"id":"P7C31P0","type":"service_reference","summary":"TEST_SERVICE","self":"https://api.pagerduty.com/services/P7C31P0","html_url":"https://your_account.pagerduty.com/services/P7C31P0"},"escalation_policy":{"id":"P5W7JL2","type":"escalation_policy_reference","summary":"MO - Sebastien Lariviere","self":"https://api.pagerduty.com/escalation_policies/P5W7JL2","html_url":"https://your_account.pagerduty.com/escalation_policies/P5W7JL2"},"assignments":[{"at":"2016-04-03T01:54:02Z","assignee":{"id":"PJGAQGT","type":"user_reference","summary":"S\u00e9bastien Larivi\u00e8re","self":"https://api.pagerduty.com/users/PJGAQGT","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"}}],"acknowledgements":[],"last_status_change_at":"2016-04-03T01:54:02Z","last_status_change_by":null,"first_trigger_log_entry":{"id":"Q0P5VKOXNK4MSF","type":"trigger_log_entry","summary":"Triggered through the website","self":"https://api.pagerduty.com/log_entries/Q0P5VKOXNK4MSF","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","created_at":"2016-04-03T01:54:02Z","channel":{"type":"web_trigger"}},"teams":[],"urgency":"low","pending_actions":[]}],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents/PO7FKW9/acknowledge").
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9.*PO7FKW0").
 		MatchHeader("Authorization", config.APIKey).
 		Reply(200).
-		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":0}`)
+		BodyString(`{"incidents":[{"id":"PO7FKW9","status":"acknowledged"},{"id":"PO7FKW0","status":"acknowledged"}]}`)
 
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents/PO7FKW0/acknowledge").
-		MatchHeader("Authorization", config.APIKey).
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Should send ack to the mentionned icident ID")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+func TestGetAssignedPDIncidentsBulkAckPartialFailure(t *testing.T) {
+	defer gock.Off()
+	defer func(previous int) { ackBatchSize = previous }(ackBatchSize)
+	ackBatchSize = 2
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=" + config.UserID).
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW9","title":"t","status":"triggered"},{"id":"PO7FKW0","title":"t2","status":"triggered"},{"id":"PO7FKW1","title":"t3","status":"triggered"}],"limit":25,"offset":0,"total":null,"more":false}`)
+
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9.*PO7FKW0").
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW9","status":"acknowledged"},{"id":"PO7FKW0","status":"resolved"}]}`)
+
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW1").
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW1","status":"acknowledged"}]}`)
+
+	b.Reset()
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "The incidents which could not be acknowledged should not fail the poll")
+	traceBuffer.Flush()
+	assert.Equal(t, gock.IsDone(), true, "Every batch should be sent")
+	assert.True(t, target.postponed(Incident{ID: "PO7FKW0"}), "The incident which could not be acknowledged should be tried again later")
+	assert.False(t, target.postponed(Incident{ID: "PO7FKW9"}), "The incidents acknowledged should not be tried again")
+	assert.Contains(t, b.String(), "Unable to acknowledge the incident PO7FKW0, still resolved", "The failed incident should be logged")
+	assert.Contains(t, b.String(), "Incident t3 (PO7FKW1) has been Acknowledged", "The next batch should be acknowledged")
+}
+
+func TestGetAssignedPDIncidentsBulkAckRefused(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=" + config.UserID).
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW9","title":"t","status":"triggered"},{"id":"PO7FKW0","title":"t2","status":"triggered"}],"limit":25,"offset":0,"total":null,"more":false}`)
+
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9.*PO7FKW0").
+		Reply(400).
+		BodyString(`{"error":{"message":"Invalid Input Provided","code":2001}}`)
+
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9").
+		Reply(400).
+		BodyString(`{"error":{"message":"Invalid Input Provided","code":2001}}`)
+
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW0").
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW0","status":"acknowledged"}]}`)
+
+	b.Reset()
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "The incidents which could not be acknowledged should not fail the poll")
+	traceBuffer.Flush()
+	assert.Equal(t, gock.IsDone(), true, "The incidents should be acknowledged one by one")
+	assert.Contains(t, b.String(), "Incident t2 (PO7FKW0) has been Acknowledged", "The bad incident should not block the other one")
+}
+
+func TestGetAssignedPDIncidentsBulkAckForbidden(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=" + config.UserID).
 		Reply(200).
-		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":0}`)
+		BodyString(`{"incidents":[{"id":"PO7FKW9","title":"t","status":"triggered"},{"id":"PO7FKW0","title":"t2","status":"triggered"}],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	assert.Equal(t, getAssignedPDIncidents(), true, "Should send ack to the mentionned icident ID")
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9.*PO7FKW0").
+		Reply(403).
+		BodyString(`{"error":{"message":"Access Denied","code":2010}}`)
+
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "The incidents which could not be acknowledged should not fail the poll")
 	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+	assert.False(t, gock.HasUnmatchedRequest(), "The incidents denied at once should not be acknowledged one by one")
+	assert.True(t, target.postponed(Incident{ID: "PO7FKW0"}), "The acknowledgements should be tried again later")
 }
 
 func TestGetAssignedPDIncidentsWithIcidentsAcked(t *testing.T) {
-	pdRetryCount = 0
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user="+config.UserID).
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
 		MatchHeader("Authorization", config.APIKey).
 		Reply(200).
-		BodyString(`{ "incidents": [ { "id": "PO7FKW9", "incident_number": 111661, "created_on": "2016-04-03T01:54:02Z", "status": "acknowledged", "pending_actions": [], "html_url": "https://your_account.pagerduty.com/incidents/PO7FKW9", "incident_key": "66274f0746384df2ad51c04c2d4069bb", "service": { "id": "P7C31P0", "name": "TEST_SERVICE", "html_url": "https://your_account.pagerduty.com/services/P7C31P0", "deleted_at": null, "description": "" }, "escalation_policy": { "id": "P5W7JL2", "name": "MO - Sebastien Lariviere", "deleted_at": null }, "assigned_to_user": { "id": "PJGAQGT", "name": "Sébastien Larivière", "email": "sebastien@lariviere.me", "html_url": "https://your_account.pagerduty.com/users/PJGAQGT" }, "trigger_summary_data": { "subject": "t" }, "trigger_details_html_url": "https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF", "trigger_type": "web_trigger", "last_status_change_on": "2016-04-03T01:54:02Z", "last_status_change_by": null, "number_of_escalations": 0, "assigned_to": [ { "at": "2016-04-03T01:54:02Z", "object": { "id": "PJGAQGT", "name": "Sébastien Larivière", "email": "sebastien@lariviere.me", "html_url": "https://your_account.pagerduty.com/users/PJGAQGT", "type": "user" } } ], "urgency": "low" }, { "id": "PO7FKW0", "incident_number": 111661, "created_on": "2016-04-03T01:54:02Z", "status": "acknowledged", "pending_actions": [], "html_url": "https://your_account.pagerduty.com/incidents/PO7FKW0", "incident_key": "66274f0746384df2ad51c04c2d4069ba", "service": { "id": "P7C31P0", "name": "TEST_SERVICE", "html_url": "https://your_account.pagerduty.com/services/P7C31P0", "deleted_at": null, "description": "" }, "escalation_policy": { "id": "P5W7JL2", "name": "MO - Sebastien Lariviere", "deleted_at": null }, "assigned_to_user": { "id": "PJGAQGT", "name": "Sébastien Larivière", "email": "sebastien@lariviere.me", "html_url": "https://your_account.pagerduty.com/users/PJGAQGT" }, "trigger_summary_data": { "subject": "t2" }, "trigger_details_html_url": "https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF", "trigger_type": "web_trigger", "last_status_change_on": "2016-04-03T01:54:02Z", "last_status_change_by": null, "number_of_escalations": 0, "assigned_to": [ { "at": "2016-04-03T01:54:02Z", "object": { "id": "PJGAQGT", "name": "Sébastien Larivière", "email": "sebastien@lariviere.me", "html_url": "https://your_account.pagerduty.com/users/PJGAQGT", "type": "user" } } ], "urgency": "low" } ], "limit": 100, "offset": 0, "total": 1 }`)
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","summary":"[#111661] t","self":"https://api.pagerduty.com/incidents/PO7FKW9","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_number":111661,"title":"t","created_at":"2016-04-03T01:54:02Z","status":"acknowledged","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","type":"service_reference","summary":"TEST_SERVICE","self":"https://api.pagerduty.com/services/P7C31P0","html_url":"https://your_account.pagerduty.com/services/P7C31P0"},"escalation_policy":{"id":"P5W7JL2","type":"escalation_policy_reference","summary":"MO - Sebastien Lariviere","self":"https://api.pagerduty.com/escalation_policies/P5W7JL2","html_url":"https://your_account.pagerduty.com/escalation_policies/P5W7JL2"},"assignments":[{"at":"2016-04-03T01:54:02Z","assignee":{"id":"PJGAQGT","type":"user_reference","summary":"S\u00e9bastien Larivi\u00e8re","self":"https://api.pagerduty.com/users/PJGAQGT","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"}}],"acknowledgements":[],"last_status_change_at":"2016-04-03T01:54:02Z","last_status_change_by":null,"first_trigger_log_entry":{"id":"Q0P5VKOXNK4MSF","type":"trigger_log_entry","summary":"Triggered through the website","self":"https://api.pagerduty.com/log_entries/Q0P5VKOXNK4MSF","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","created_at":"2016-04-03T01:54:02Z","channel":{"type":"web_trigger"}},"teams":[],"urgency":"low","pending_actions":[]},{"id":"PO7FKW0","type":"incident","summary":"[#111661] t2","self":"https://api.pagerduty.com/incidents/PO7FKW0","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW0","incident_number":111661,"title":"t2","created_at":"2016-04-03T01:54:02Z","status":"acknowledged","incident_key":"66274f0746384df2ad51c04c2d4069ba","service":{"id":"P7C31P0","type":"service_reference","summary":"TEST_SERVICE","self":"https://api.pagerduty.com/services/P7C31P0","html_url":"https://your_account.pagerduty.com/services/P7C31P0"},"escalation_policy":{"id":"P5W7JL2","type":"escalation_policy_reference","summary":"MO - Sebastien Lariviere","self":"https://api.pagerduty.com/escalation_policies/P5W7JL2","html_url":"https://your_account.pagerduty.com/escalation_policies/P5W7JL2"},"assignments":[{"at":"2016-04-03T01:54:02Z","assignee":{"id":"PJGAQGT","type":"user_reference","summary":"S\u00e9bastien Larivi\u00e8re","self":"https://api.pagerduty.com/users/PJGAQGT","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"}}],"acknowledgements":[],"last_status_change_at":"2016-04-03T01:54:02Z","last_status_change_by":null,"first_trigger_log_entry":{"id":"Q0P5VKOXNK4MSF","type":"trigger_log_entry","summary":"Triggered through the website","self":"https://api.pagerduty.com/log_entries/Q0P5VKOXNK4MSF","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","created_at":"2016-04-03T01:54:02Z","channel":{"type":"web_trigger"}},"teams":[],"urgency":"low","pending_actions":[]}],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	assert.Equal(t, getAssignedPDIncidents(), true, "Should send ack to the mentionned icident ID")
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Should send ack to the mentionned icident ID")
 	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
 }
 
+func TestGetAssignedPDIncidentsPaginated(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
+		MatchParam("offset", "^0$").
+		MatchHeader("Authorization", config.APIKey).
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","incident_number":111661,"title":"t","status":"triggered"}],"limit":1,"offset":0,"total":null,"more":true}`)
+
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
+		MatchParam("offset", "^1$").
+		MatchHeader("Authorization", config.APIKey).
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW0","type":"incident","incident_number":111662,"title":"t2","status":"triggered"}],"limit":1,"offset":1,"total":null,"more":false}`)
+
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9.*PO7FKW0").
+		MatchHeader("Authorization", config.APIKey).
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW9","status":"acknowledged"},{"id":"PO7FKW0","status":"acknowledged"}]}`)
+
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Should follow the pages and ack the incidents of every page at once")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+func TestGetAssignedPDIncidentsMaxPages(t *testing.T) {
+	defer gock.Off()
+	defer func() { config.MaxPages = 0 }()
+	config.MaxPages = 1
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
+		MatchParam("offset", "^0$").
+		MatchHeader("Authorization", config.APIKey).
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","incident_number":111661,"title":"t","status":"acknowledged"}],"limit":1,"offset":0,"total":null,"more":true}`)
+
+	// Fetching the second page would not match any mock and fail
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Should stop fetching pages once maxPages is reached")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+func TestGetAssignedPDIncidentsPageFails(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
+		MatchParam("offset", "^0$").
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","incident_number":111661,"title":"t","status":"acknowledged"}],"limit":1,"offset":0,"total":null,"more":true}`)
+
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
+		MatchParam("offset", "^1$").
+		Reply(400).
+		BodyString(`{"error":{"message":"Invalid Input Provided","code":2001}}`)
+
+	assert.Equal(t, target.getAssignedPDIncidents(), false, "Response code of the second page is 400, getAssignedPDIncidents should return false")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+func TestGetAssignedPDIncidentsWithRules(t *testing.T) {
+	defer gock.Off()
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	config.Rules = []Rule{{Name: "test-service", Services: []string{"TEST_SERVICE"}}}
+	compileRules(config.Rules)
+	target := newTestTarget()
+
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
+		MatchHeader("Authorization", config.APIKey).
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","incident_number":111661,"title":"t","status":"triggered","service":{"id":"P7C31P0","summary":"TEST_SERVICE"}},{"id":"PO7FKW0","type":"incident","incident_number":111662,"title":"t2","status":"triggered","service":{"id":"P7C31P1","summary":"OTHER_SERVICE"}}],"limit":100,"offset":0,"total":null,"more":false}`)
+
+	// Only the incident matching the rule is acknowledged
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9").
+		MatchHeader("Authorization", config.APIKey).
+		Reply(200).
+		BodyString(`{"incidents":[]}`)
+
+	b.Reset()
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Should only ack the incident matching the rule")
+	traceBuffer.Flush()
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+	assert.True(t, strings.Contains(b.String(), "matched by rule test-service"), "Expected the matching rule in the logs: %s", b.String())
+	assert.True(t, strings.Contains(b.String(), "PO7FKW0) has been skipped"), "Expected the skipped incident in the logs: %s", b.String())
+}
+
+func TestGetAssignedPDIncidentsDryRun(t *testing.T) {
+	defer gock.Off()
+	defer func() { *dryRun = false }()
+	*dryRun = true
+	target := newTestTarget()
+
+	// No acknowledgement is planned, sending one would fail to match any mock
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
+		MatchHeader("Authorization", config.APIKey).
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","incident_number":111661,"title":"t","status":"triggered"},{"id":"PO7FKW0","type":"incident","incident_number":111662,"title":"t2","status":"acknowledged"}],"limit":100,"offset":0,"total":null,"more":false}`)
+
+	b.Reset()
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Dry runs should only poll PD")
+	traceBuffer.Flush()
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+	assert.True(t, strings.Contains(b.String(), "[dry-run] Incident t (PO7FKW9) would be acknowledged"), "Expected the action in the logs: %s", b.String())
+	assert.True(t, strings.Contains(b.String(), "1 acknowledged, 1 triggered, 1 would be acted upon, 0 skipped"), "Expected the summary in the logs: %s", b.String())
+}
+
 func TestGetAssignedPDIncidentsWithAckBadRequest(t *testing.T) {
-	pdRetryCount = 0
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents?assigned_to_user="+config.UserID).
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
 		MatchHeader("Authorization", config.APIKey).
 		Reply(200).
-		BodyString(`{"incidents":[{"id":"PO7FKW9","incident_number":111661,"created_on":"2016-04-03T01:54:02Z","status":"triggered","pending_actions":[],"html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","name":"TEST_SERVICE","html_url":"https://your_account.pagerduty.com/services/P7C31P0","deleted_at":null,"description":""},"escalation_policy":{"id":"P5W7JL2","name":"MO - Sebastien Lariviere","deleted_at":null},"assigned_to_user":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"},"trigger_summary_data":{"subject":"t"},"trigger_details_html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","trigger_type":"web_trigger","last_status_change_on":"2016-04-03T01:54:02Z","last_status_change_by":null,"number_of_escalations":0,"assigned_to":[{"at":"2016-04-03T01:54:02Z","object":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me","html_url":"https://your_account.pagerduty.com/users/PJGAQGT","type":"user"}}],"urgency":"low"}],"limit":100,"offset":0,"total":1}`)
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","summary":"[#111661] t","self":"https://api.pagerduty.com/incidents/PO7FKW9","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_number":111661,"title":"t","created_at":"2016-04-03T01:54:02Z","status":"triggered","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","type":"service_reference","summary":"TEST_SERVICE","self":"https://api.pagerduty.com/services/P7C31P0","html_url":"https://your_account.pagerduty.com/services/P7C31P0"},"escalation_policy":{"id":"P5W7JL2","type":"escalation_policy_reference","summary":"MO - Sebastien Lariviere","self":"https://api.pagerduty.com/escalation_policies/P5W7JL2","html_url":"https://your_account.pagerduty.com/escalation_policies/P5W7JL2"},"assignments":[{"at":"2016-04-03T01:54:02Z","assignee":{"id":"PJGAQGT","type":"user_reference","summary":"S\u00e9bastien Larivi\u00e8re","self":"https://api.pagerduty.com/users/PJGAQGT","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"}}],"acknowledgements":[],"last_status_change_at":"2016-04-03T01:54:02Z","last_status_change_by":null,"first_trigger_log_entry":{"id":"Q0P5VKOXNK4MSF","type":"trigger_log_entry","summary":"Triggered through the website","self":"https://api.pagerduty.com/log_entries/Q0P5VKOXNK4MSF","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","created_at":"2016-04-03T01:54:02Z","channel":{"type":"web_trigger"}},"teams":[],"urgency":"low","pending_actions":[]}],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	gock.New("https://"+config.Account+".pagerduty.com/api/v1/incidents/PO7FKW9/acknowledge").
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9").
 		MatchHeader("Authorization", config.APIKey).
 		Reply(400).
-		BodyString(`{"incidents":[],"limit":100,"offset":0,"total":0}`)
+		BodyString(`{"incidents":[],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	assert.Equal(t, getAssignedPDIncidents(), false, "Should send ack to the mentionned icident ID, then get a 400, thus failing")
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Should send ack to the mentionned icident ID, then get a 400, and try again later")
+	assert.True(t, target.postponed(newTestIncident()), "The acknowledgement should be tried again later")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+func TestGetAssignedPDIncidentsWithAckForbidden(t *testing.T) {
+	defer gock.Off()
+	defer func() { health = newHealthRegistry() }()
+	health = newHealthRegistry()
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
+		MatchHeader("Authorization", config.APIKey).
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW9","title":"t","status":"triggered","urgency":"low"}],"limit":25,"offset":0,"total":null,"more":false}`)
+
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9").
+		MatchHeader("Authorization", config.APIKey).
+		Reply(403).
+		BodyString(`{"error":{"message":"Access Denied","code":2010,"errors":["Read-only API keys cannot update incidents."]}}`)
+
+	traceBuffer.Flush()
+	b.Reset()
+	target.getAssignedPDIncidents()
+	traceBuffer.Flush()
+	assert.Equal(t, target.getFatal(), 0, "An update denied should not stop the target")
+	assert.True(t, target.postponed(newTestIncident()), "The acknowledgement should be tried again later")
+	assert.Contains(t, b.String(), "Use a full access REST API key rather than a read-only one", "The remediation should be logged")
+	assert.Contains(t, b.String(), "Access Denied (2010): Read-only API keys cannot update incidents.", "The details of PagerDuty should be logged")
+	report, _ := health.report(false)
+	assert.False(t, report.Targets[0].AccessDenied, "An update denied should not be reported as fatal")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+}
+
+func TestGetAssignedPDIncidentsForbidden(t *testing.T) {
+	defer gock.Off()
+	defer func() { health = newHealthRegistry() }()
+	health = newHealthRegistry()
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]="+config.UserID).
+		MatchHeader("Authorization", config.APIKey).
+		Reply(403).
+		BodyString(`{"error":{"message":"Access Denied","code":2010}}`)
+
+	assert.Equal(t, target.getAssignedPDIncidents(), false, "The poll should fail")
+	assert.Equal(t, target.getFatal(), exitAccessDenied, "An API key not allowed to list the incidents should stop the target")
+	report, _ := health.report(false)
+	assert.Equal(t, report.Targets[0].Reason, "PagerDuty denied the API key access", "Invalid reason")
 	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
 }
 
 // TestMain tests the main function
 func TestMain(t *testing.T) {
-	os.Args = []string{os.Args[0], "--conf=pdack_sample.conf"}
+	os.Args = []string{os.Args[0], "--conf=" + privateCopy(t, "pdack_sample.conf")}
 
-	pdRetryCount = 0
 	testExitCode = 0
-	gock.New("https://your_account.pagerduty.com/api/v1/incidents?assigned_to_user=XXXXXXX").
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=XXXXXXX").
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","summary":"[#111661] t","self":"https://api.pagerduty.com/incidents/PO7FKW9","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_number":111661,"title":"t","created_at":"2016-04-03T01:54:02Z","status":"triggered","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","type":"service_reference","summary":"TEST_SERVICE","self":"https://api.pagerduty.com/services/P7C31P0","html_url":"https://your_account.pagerduty.com/services/P7C31P0"},"escalation_policy":{"id":"P5W7JL2","type":"escalation_policy_reference","summary":"MO - Sebastien Lariviere","self":"https://api.pagerduty.com/escalation_policies/P5W7JL2","html_url":"https://your_account.pagerduty.com/escalation_policies/P5W7JL2"},"assignments":[{"at":"2016-04-03T01:54:02Z","assignee":{"id":"PJGAQGT","type":"user_reference","summary":"S\u00e9bastien Larivi\u00e8re","self":"https://api.pagerduty.com/users/PJGAQGT","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"}}],"acknowledgements":[],"last_status_change_at":"2016-04-03T01:54:02Z","last_status_change_by":null,"first_trigger_log_entry":{"id":"Q0P5VKOXNK4MSF","type":"trigger_log_entry","summary":"Triggered through the website","self":"https://api.pagerduty.com/log_entries/Q0P5VKOXNK4MSF","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","created_at":"2016-04-03T01:54:02Z","channel":{"type":"web_trigger"}},"teams":[],"urgency":"low","pending_actions":[]}],"limit":25,"offset":0,"total":null,"more":false}`)
+
+	gock.New("https://api.pagerduty.com/users/XXXXXXX").
 		Reply(200).
-		BodyString(`{"incidents":[{"id":"PO7FKW9","incident_number":111661,"created_on":"2016-04-03T01:54:02Z","status":"triggered","pending_actions":[],"html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","name":"TEST_SERVICE","html_url":"https://your_account.pagerduty.com/services/P7C31P0","deleted_at":null,"description":""},"escalation_policy":{"id":"P5W7JL2","name":"MO - Sebastien Lariviere","deleted_at":null},"assigned_to_user":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"},"trigger_summary_data":{"subject":"t"},"trigger_details_html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","trigger_type":"web_trigger","last_status_change_on":"2016-04-03T01:54:02Z","last_status_change_by":null,"number_of_escalations":0,"assigned_to":[{"at":"2016-04-03T01:54:02Z","object":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me","html_url":"https://your_account.pagerduty.com/users/PJGAQGT","type":"user"}}],"urgency":"low"}],"limit":100,"offset":0,"total":1}`)
+		BodyString(`{"user":{"id":"XXXXXXX","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me"}}`)
 
-	// Faking PD outage so the program exit
-	gock.New("https://your_account.pagerduty.com/api/v1/incidents/PO7FKW9/acknowledge").
+	// Faking PD outage, the acknowledgement is tried again later
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9").
 		MatchHeader("Authorization", config.APIKey).
 		Reply(500).
-		BodyString(`{"incidents":[{"id":"PO7FKW9","incident_number":111661,"created_on":"2016-04-03T01:54:02Z","status":"triggered","pending_actions":[],"html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","name":"TEST_SERVICE","html_url":"https://your_account.pagerduty.com/services/P7C31P0","deleted_at":null,"description":""},"escalation_policy":{"id":"P5W7JL2","name":"MO - Sebastien Lariviere","deleted_at":null},"assigned_to_user":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"},"trigger_summary_data":{"subject":"t"},"trigger_details_html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","trigger_type":"web_trigger","last_status_change_on":"2016-04-03T01:54:02Z","last_status_change_by":null,"number_of_escalations":0,"assigned_to":[{"at":"2016-04-03T01:54:02Z","object":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me","html_url":"https://your_account.pagerduty.com/users/PJGAQGT","type":"user"}}],"urgency":"low"}],"limit":100,"offset":0,"total":1}`)
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","summary":"[#111661] t","self":"https://api.pagerduty.com/incidents/PO7FKW9","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_number":111661,"title":"t","created_at":"2016-04-03T01:54:02Z","status":"triggered","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","type":"service_reference","summary":"TEST_SERVICE","self":"https://api.pagerduty.com/services/P7C31P0","html_url":"https://your_account.pagerduty.com/services/P7C31P0"},"escalation_policy":{"id":"P5W7JL2","type":"escalation_policy_reference","summary":"MO - Sebastien Lariviere","self":"https://api.pagerduty.com/escalation_policies/P5W7JL2","html_url":"https://your_account.pagerduty.com/escalation_policies/P5W7JL2"},"assignments":[{"at":"2016-04-03T01:54:02Z","assignee":{"id":"PJGAQGT","type":"user_reference","summary":"S\u00e9bastien Larivi\u00e8re","self":"https://api.pagerduty.com/users/PJGAQGT","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"}}],"acknowledgements":[],"last_status_change_at":"2016-04-03T01:54:02Z","last_status_change_by":null,"first_trigger_log_entry":{"id":"Q0P5VKOXNK4MSF","type":"trigger_log_entry","summary":"Triggered through the website","self":"https://api.pagerduty.com/log_entries/Q0P5VKOXNK4MSF","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","created_at":"2016-04-03T01:54:02Z","channel":{"type":"web_trigger"}},"teams":[],"urgency":"low","pending_actions":[]}],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	gock.New("https://your_account.pagerduty.com/api/v1/incidents/PO7FKW9/acknowledge").
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9").
 		MatchHeader("Authorization", config.APIKey).
 		Reply(500).
-		BodyString(`{"incidents":[{"id":"PO7FKW9","incident_number":111661,"created_on":"2016-04-03T01:54:02Z","status":"triggered","pending_actions":[],"html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","name":"TEST_SERVICE","html_url":"https://your_account.pagerduty.com/services/P7C31P0","deleted_at":null,"description":""},"escalation_policy":{"id":"P5W7JL2","name":"MO - Sebastien Lariviere","deleted_at":null},"assigned_to_user":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"},"trigger_summary_data":{"subject":"t"},"trigger_details_html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","trigger_type":"web_trigger","last_status_change_on":"2016-04-03T01:54:02Z","last_status_change_by":null,"number_of_escalations":0,"assigned_to":[{"at":"2016-04-03T01:54:02Z","object":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me","html_url":"https://your_account.pagerduty.com/users/PJGAQGT","type":"user"}}],"urgency":"low"}],"limit":100,"offset":0,"total":1}`)
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","summary":"[#111661] t","self":"https://api.pagerduty.com/incidents/PO7FKW9","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_number":111661,"title":"t","created_at":"2016-04-03T01:54:02Z","status":"triggered","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","type":"service_reference","summary":"TEST_SERVICE","self":"https://api.pagerduty.com/services/P7C31P0","html_url":"https://your_account.pagerduty.com/services/P7C31P0"},"escalation_policy":{"id":"P5W7JL2","type":"escalation_policy_reference","summary":"MO - Sebastien Lariviere","self":"https://api.pagerduty.com/escalation_policies/P5W7JL2","html_url":"https://your_account.pagerduty.com/escalation_policies/P5W7JL2"},"assignments":[{"at":"2016-04-03T01:54:02Z","assignee":{"id":"PJGAQGT","type":"user_reference","summary":"S\u00e9bastien Larivi\u00e8re","self":"https://api.pagerduty.com/users/PJGAQGT","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"}}],"acknowledgements":[],"last_status_change_at":"2016-04-03T01:54:02Z","last_status_change_by":null,"first_trigger_log_entry":{"id":"Q0P5VKOXNK4MSF","type":"trigger_log_entry","summary":"Triggered through the website","self":"https://api.pagerduty.com/log_entries/Q0P5VKOXNK4MSF","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","created_at":"2016-04-03T01:54:02Z","channel":{"type":"web_trigger"}},"teams":[],"urgency":"low","pending_actions":[]}],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	gock.New("https://your_account.pagerduty.com/api/v1/incidents/PO7FKW9/acknowledge").
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9").
 		MatchHeader("Authorization", config.APIKey).
 		Reply(500).
-		BodyString(`{"incidents":[{"id":"PO7FKW9","incident_number":111661,"created_on":"2016-04-03T01:54:02Z","status":"triggered","pending_actions":[],"html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","name":"TEST_SERVICE","html_url":"https://your_account.pagerduty.com/services/P7C31P0","deleted_at":null,"description":""},"escalation_policy":{"id":"P5W7JL2","name":"MO - Sebastien Lariviere","deleted_at":null},"assigned_to_user":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"},"trigger_summary_data":{"subject":"t"},"trigger_details_html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","trigger_type":"web_trigger","last_status_change_on":"2016-04-03T01:54:02Z","last_status_change_by":null,"number_of_escalations":0,"assigned_to":[{"at":"2016-04-03T01:54:02Z","object":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me","html_url":"https://your_account.pagerduty.com/users/PJGAQGT","type":"user"}}],"urgency":"low"}],"limit":100,"offset":0,"total":1}`)
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","summary":"[#111661] t","self":"https://api.pagerduty.com/incidents/PO7FKW9","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_number":111661,"title":"t","created_at":"2016-04-03T01:54:02Z","status":"triggered","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","type":"service_reference","summary":"TEST_SERVICE","self":"https://api.pagerduty.com/services/P7C31P0","html_url":"https://your_account.pagerduty.com/services/P7C31P0"},"escalation_policy":{"id":"P5W7JL2","type":"escalation_policy_reference","summary":"MO - Sebastien Lariviere","self":"https://api.pagerduty.com/escalation_policies/P5W7JL2","html_url":"https://your_account.pagerduty.com/escalation_policies/P5W7JL2"},"assignments":[{"at":"2016-04-03T01:54:02Z","assignee":{"id":"PJGAQGT","type":"user_reference","summary":"S\u00e9bastien Larivi\u00e8re","self":"https://api.pagerduty.com/users/PJGAQGT","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"}}],"acknowledgements":[],"last_status_change_at":"2016-04-03T01:54:02Z","last_status_change_by":null,"first_trigger_log_entry":{"id":"Q0P5VKOXNK4MSF","type":"trigger_log_entry","summary":"Triggered through the website","self":"https://api.pagerduty.com/log_entries/Q0P5VKOXNK4MSF","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","created_at":"2016-04-03T01:54:02Z","channel":{"type":"web_trigger"}},"teams":[],"urgency":"low","pending_actions":[]}],"limit":25,"offset":0,"total":null,"more":false}`)
 
-	gock.New("https://your_account.pagerduty.com/api/v1/incidents/PO7FKW9/acknowledge").
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9").
 		MatchHeader("Authorization", config.APIKey).
 		Reply(500).
-		BodyString(`{"incidents":[{"id":"PO7FKW9","incident_number":111661,"created_on":"2016-04-03T01:54:02Z","status":"triggered","pending_actions":[],"html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","name":"TEST_SERVICE","html_url":"https://your_account.pagerduty.com/services/P7C31P0","deleted_at":null,"description":""},"escalation_policy":{"id":"P5W7JL2","name":"MO - Sebastien Lariviere","deleted_at":null},"assigned_to_user":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"},"trigger_summary_data":{"subject":"t"},"trigger_details_html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","trigger_type":"web_trigger","last_status_change_on":"2016-04-03T01:54:02Z","last_status_change_by":null,"number_of_escalations":0,"assigned_to":[{"at":"2016-04-03T01:54:02Z","object":{"id":"PJGAQGT","name":"S\u00e9bastien Larivi\u00e8re","email":"sebastien@lariviere.me","html_url":"https://your_account.pagerduty.com/users/PJGAQGT","type":"user"}}],"urgency":"low"}],"limit":100,"offset":0,"total":1}`)
-
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","summary":"[#111661] t","self":"https://api.pagerduty.com/incidents/PO7FKW9","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9","incident_number":111661,"title":"t","created_at":"2016-04-03T01:54:02Z","status":"triggered","incident_key":"66274f0746384df2ad51c04c2d4069bb","service":{"id":"P7C31P0","type":"service_reference","summary":"TEST_SERVICE","self":"https://api.pagerduty.com/services/P7C31P0","html_url":"https://your_account.pagerduty.com/services/P7C31P0"},"escalation_policy":{"id":"P5W7JL2","type":"escalation_policy_reference","summary":"MO - Sebastien Lariviere","self":"https://api.pagerduty.com/escalation_policies/P5W7JL2","html_url":"https://your_account.pagerduty.com/escalation_policies/P5W7JL2"},"assignments":[{"at":"2016-04-03T01:54:02Z","assignee":{"id":"PJGAQGT","type":"user_reference","summary":"S\u00e9bastien Larivi\u00e8re","self":"https://api.pagerduty.com/users/PJGAQGT","html_url":"https://your_account.pagerduty.com/users/PJGAQGT"}}],"acknowledgements":[],"last_status_change_at":"2016-04-03T01:54:02Z","last_status_change_by":null,"first_trigger_log_entry":{"id":"Q0P5VKOXNK4MSF","type":"trigger_log_entry","summary":"Triggered through the website","self":"https://api.pagerduty.com/log_entries/Q0P5VKOXNK4MSF","html_url":"https://your_account.pagerduty.com/incidents/PO7FKW9/log_entries/Q0P5VKOXNK4MSF","created_at":"2016-04-03T01:54:02Z","channel":{"type":"web_trigger"}},"teams":[],"urgency":"low","pending_actions":[]}],"limit":25,"offset":0,"total":null,"more":false}`)
+
+	b.Reset()
+	testExitCode = -1
+	// The retries are given up once pdack is asked to stop
+	go func() {
+		for deadline := time.Now().Add(5 * time.Second); !gock.IsDone() && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		signals <- syscall.SIGTERM
+	}()
 	main()
+	traceBuffer.Flush()
 	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
-	assert.Equal(t, testExitCode, 1, "Program should have exited with code 1")
+	assert.Equal(t, testExitCode, exitOK, "An outage should not stop the program")
+	assert.Contains(t, b.String(), "Incident t (PO7FKW9) will be acted upon again after", "The acknowledgement should be tried again later")
 }
 
 // TestMain tests the main function
 func TestMainBadConfigurationFile(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
 	os.Args = []string{os.Args[0], "--conf=pdack_does_not_exists.conf"}
-	pdRetryCount = 0
 	testExitCode = 0
 	main()
 	assert.Equal(t, testExitCode, 1, "Program should have exited with code 1")
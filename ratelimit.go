@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultRequestsPerMinute stays below the 960 requests per minute
+// PagerDuty allows per API key
+var defaultRequestsPerMinute = 600
+
+// RateLimiter is a token bucket spacing the requests sent with an API key,
+// holding up to a tenth of the requests allowed per minute for the bursts
+type RateLimiter struct {
+	mutex     sync.Mutex
+	perMinute int
+	tokens    float64
+	last      time.Time
+}
+
+// limiters are the rate limiters by API key, the targets sharing an API key
+// share its limit
+var limiters = map[string]*RateLimiter{}
+var limitersMutex sync.Mutex
+
+// getRateLimiter returns the rate limiter of the API key, allowing
+// perMinute requests per minute from now on
+func getRateLimiter(apiKey string, perMinute int) *RateLimiter {
+	limitersMutex.Lock()
+	defer limitersMutex.Unlock()
+	limiter, found := limiters[apiKey]
+	if !found {
+		limiter = &RateLimiter{tokens: burstSize(perMinute), last: now()}
+		limiters[apiKey] = limiter
+	}
+	limiter.mutex.Lock()
+	limiter.perMinute = perMinute
+	limiter.mutex.Unlock()
+	return limiter
+}
+
+func burstSize(perMinute int) float64 {
+	if perMinute < 10 {
+		return 1
+	}
+	return float64(perMinute / 10)
+}
+
+// reserve takes a token for a request and returns how long to wait before
+// sending it, the requests waiting being served in order
+func (limiter *RateLimiter) reserve() time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	perSecond := float64(limiter.perMinute) / 60
+	// The clock may go back, the refill only counts the time elapsed
+	if current := now(); current.After(limiter.last) {
+		limiter.tokens += current.Sub(limiter.last).Seconds() * perSecond
+		limiter.last = current
+	}
+	if burst := burstSize(limiter.perMinute); limiter.tokens > burst {
+		limiter.tokens = burst
+	}
+	limiter.tokens--
+	if limiter.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-limiter.tokens / perSecond * float64(time.Second))
+}
+
+// waitForRateLimit waits until the API key of the target allows another
+// request, returning false when the target is asked to stop meanwhile
+func (target *Target) waitForRateLimit() bool {
+	wait := target.limiter.reserve()
+	if wait <= 0 {
+		return true
+	}
+	target.logf("Waiting %s before the next request, %d requests per minute are allowed with the API key", wait.Round(time.Millisecond), target.getRequestsPerMinute())
+	metrics.add("pdack_rate_limit_wait_seconds_total", metricLabels("target", target.metricLabel()), wait.Seconds())
+	return target.sleep(wait)
+}
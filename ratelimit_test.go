@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	defer func(saved map[string]*RateLimiter) { now, limiters = time.Now, saved }(limiters)
+	limiters = map[string]*RateLimiter{}
+	start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+
+	limiter := getRateLimiter("rate-limited", 60)
+	for i := 0; i < 6; i++ {
+		assert.Equal(t, limiter.reserve(), time.Duration(0), "The burst should be sent right away")
+	}
+	assert.Equal(t, limiter.reserve(), time.Second, "The next request should wait for a token")
+	assert.Equal(t, limiter.reserve(), 2*time.Second, "The requests waiting should be served in order")
+
+	now = func() time.Time { return start.Add(time.Minute) }
+	assert.Equal(t, limiter.reserve(), time.Duration(0), "The tokens should be refilled over time")
+	assert.Equal(t, getRateLimiter("rate-limited", 120), limiter, "The targets sharing an API key should share its limiter")
+	assert.NotEqual(t, getRateLimiter("another-key", 60), limiter, "Every API key should have its own limiter")
+}
+
+func TestWaitForRateLimit(t *testing.T) {
+	target := newTestTarget()
+	target.RequestsPerMinute = 6000
+	target.limiter = &RateLimiter{perMinute: 6000, last: now()}
+
+	b.Reset()
+	start := time.Now()
+	target.waitForRateLimit()
+	traceBuffer.Flush()
+	assert.True(t, time.Since(start) >= 5*time.Millisecond, "The request should wait for a token")
+	assert.Contains(t, b.String(), "6000 requests per minute are allowed with the API key", "The wait should be logged")
+}
+
+func TestSharedRateLimit(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	for _, perMinute := range [][]int{{60, 120}, {120, 60}} {
+		config.targets = []PagerDutyConfig{
+			{Name: "alice", APIKey: "shared-key", UserID: "PALICE1", RequestsPerMinute: perMinute[0]},
+			{Name: "bob", APIKey: "shared-key", UserID: "PBOB001", RequestsPerMinute: perMinute[1]},
+		}
+		targets := getTargets()
+		assert.Equal(t, targets[0].limiter, targets[1].limiter, "The targets sharing an API key should share its limiter")
+		assert.Equal(t, targets[0].limiter.perMinute, 60, "The lowest limit should apply whatever the order of the targets")
+		assert.Equal(t, targets[1].getRequestsPerMinute(), 60, "Every target should be given the lowest limit")
+	}
+}
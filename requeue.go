@@ -0,0 +1,64 @@
+package main
+
+import (
+	"time"
+)
+
+// failedActionDelay is how long an action which failed on an incident waits
+// before being tried again, doubled after every failure up to
+// failedActionMaxDelay
+var failedActionDelay = time.Minute
+var failedActionMaxDelay = 30 * time.Minute
+
+// failedAction is an action which failed on an incident, tried again by the
+// first poll after retryAt
+type failedAction struct {
+	failures int
+	retryAt  time.Time
+}
+
+// requeue records the outcome of an action on the incident. After a failure
+// the action is tried again on a later poll, waiting longer after every
+// failure
+func (target *Target) requeue(incident Incident, success bool) {
+	if success {
+		delete(target.failedActions, incident.ID)
+		return
+	}
+	failed, found := target.failedActions[incident.ID]
+	if !found {
+		failed = &failedAction{}
+		target.failedActions[incident.ID] = failed
+	}
+	failed.failures++
+	delay := failedActionDelay
+	for i := 1; i < failed.failures && delay < failedActionMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > failedActionMaxDelay {
+		delay = failedActionMaxDelay
+	}
+	failed.retryAt = now().Add(delay)
+	target.logf("Incident %s (%s) will be acted upon again after %s, %d failures so far", incident.Title, incident.ID, failed.retryAt.Format(time.RFC3339), failed.failures)
+}
+
+// postponed returns true while the action which failed on the incident
+// waits to be tried again
+func (target *Target) postponed(incident Incident) bool {
+	failed, found := target.failedActions[incident.ID]
+	return found && now().Before(failed.retryAt)
+}
+
+// forgetFailedActions drops the failed actions of the incidents which are
+// no longer triggered, acknowledged or resolved by someone else meanwhile
+func (target *Target) forgetFailedActions(incidents []Incident) {
+	triggered := map[string]bool{}
+	for _, incident := range incidents {
+		triggered[incident.ID] = incident.Status == "triggered"
+	}
+	for id := range target.failedActions {
+		if !triggered[id] {
+			delete(target.failedActions, id)
+		}
+	}
+}
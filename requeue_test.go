@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequeue(t *testing.T) {
+	defer func() { now = time.Now }()
+	start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+
+	target := newTestTarget()
+	incident := newTestIncident()
+	assert.False(t, target.postponed(incident), "An incident without failure should be acted upon")
+
+	target.requeue(incident, false)
+	assert.True(t, target.postponed(incident), "A failed action should wait before being tried again")
+	assert.Equal(t, target.failedActions[incident.ID].retryAt, start.Add(failedActionDelay), "Invalid delay after the first failure")
+
+	target.requeue(incident, false)
+	assert.Equal(t, target.failedActions[incident.ID].retryAt, start.Add(2*failedActionDelay), "The delay should double after every failure")
+	for i := 0; i < 10; i++ {
+		target.requeue(incident, false)
+	}
+	assert.Equal(t, target.failedActions[incident.ID].retryAt, start.Add(failedActionMaxDelay), "The delay should not exceed failedActionMaxDelay")
+
+	now = func() time.Time { return start.Add(time.Hour) }
+	assert.False(t, target.postponed(incident), "The action should be tried again once the delay elapsed")
+
+	target.requeue(incident, true)
+	assert.Empty(t, target.failedActions, "A successful action should be forgotten")
+
+	target.requeue(incident, false)
+	target.forgetFailedActions([]Incident{{ID: incident.ID, Status: "acknowledged"}})
+	assert.Empty(t, target.failedActions, "The incidents no longer triggered should be forgotten")
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+)
+
+// Rule describes which incidents are acted upon. Every field that is set
+// must match the incident, fields left empty match any incident
+type Rule struct {
+	Name               string
+	Exclude            bool
+	ServiceIDs         []string
+	Services           []string
+	EscalationPolicies []string
+	Urgency            string
+	Subject            string
+	IncidentKey        string
+	TriggerTypes       []string
+
+	Action                 string
+	SnoozeFor              string
+	AssignUser             string
+	AssignEscalationPolicy string
+	SetUrgency             string
+	Note                   string
+
+	Windows       []string
+	ExceptWindows []string
+
+	FlapCount  int
+	FlapWithin string
+	FlapBy     string
+
+	subjectRegexp     *regexp.Regexp
+	incidentKeyRegexp *regexp.Regexp
+	snoozeDuration    time.Duration
+	windows           []*Window
+	exceptWindows     []*Window
+	flapWithin        time.Duration
+}
+
+// compileRules validates the rules and compiles their regular expressions
+func compileRules(rules []Rule) (success bool) {
+	success = true
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("#%d", i+1)
+		}
+		if rule.Urgency != "" && rule.Urgency != "high" && rule.Urgency != "low" {
+			log.Printf("An error occured while reading the rule %s, urgency must be high or low", rule.Name)
+			success = false
+		}
+		var err error
+		if rule.subjectRegexp, err = compileRuleRegexp(rule.Subject); err != nil {
+			log.Printf("An error occured while reading the rule %s, invalid subject: %s", rule.Name, err)
+			success = false
+		}
+		if rule.incidentKeyRegexp, err = compileRuleRegexp(rule.IncidentKey); err != nil {
+			log.Printf("An error occured while reading the rule %s, invalid incidentKey: %s", rule.Name, err)
+			success = false
+		}
+		if !compileRuleAction(rule) {
+			success = false
+		}
+		if !compileRuleFlapping(rule) {
+			success = false
+		}
+		if rule.windows, err = findWindows(rule.Windows); err != nil {
+			log.Printf("An error occured while reading the rule %s, %s", rule.Name, err)
+			success = false
+		}
+		if rule.exceptWindows, err = findWindows(rule.ExceptWindows); err != nil {
+			log.Printf("An error occured while reading the rule %s, %s", rule.Name, err)
+			success = false
+		}
+	}
+	return success
+}
+
+// findWindows returns the windows of the configuration with the given names
+func findWindows(names []string) (windows []*Window, err error) {
+	for _, name := range names {
+		var found *Window
+		for i := range config.Windows {
+			if config.Windows[i].Name == name {
+				found = &config.Windows[i]
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("unknown window %s", name)
+		}
+		windows = append(windows, found)
+	}
+	return windows, nil
+}
+
+// compileRuleAction validates the action of the rule and its parameters
+func compileRuleAction(rule *Rule) (success bool) {
+	switch rule.Action {
+	case "", actionAcknowledge, actionResolve:
+		return true
+	case actionSnooze:
+		var err error
+		if rule.snoozeDuration, err = time.ParseDuration(rule.SnoozeFor); err != nil || rule.snoozeDuration < time.Minute {
+			log.Printf("An error occured while reading the rule %s, snoozeFor must be a duration of at least 1m", rule.Name)
+			return false
+		}
+	case actionReassign:
+		if (rule.AssignUser == "") == (rule.AssignEscalationPolicy == "") {
+			log.Printf("An error occured while reading the rule %s, either assignUser or assignEscalationPolicy is required", rule.Name)
+			return false
+		}
+	case actionUrgency:
+		if rule.SetUrgency != "high" && rule.SetUrgency != "low" {
+			log.Printf("An error occured while reading the rule %s, setUrgency must be high or low", rule.Name)
+			return false
+		}
+	case actionNote:
+		if rule.Note == "" {
+			log.Printf("An error occured while reading the rule %s, note is required", rule.Name)
+			return false
+		}
+	default:
+		log.Printf("An error occured while reading the rule %s, unknown action %s", rule.Name, rule.Action)
+		return false
+	}
+	return true
+}
+
+// compileRuleFlapping validates how the rule detects flapping incidents
+func compileRuleFlapping(rule *Rule) (success bool) {
+	if rule.FlapBy != "" && rule.FlapBy != "incidentKey" && rule.FlapBy != "subject" {
+		log.Printf("An error occured while reading the rule %s, flapBy must be incidentKey or subject", rule.Name)
+		return false
+	}
+	if rule.FlapCount < 0 {
+		log.Printf("An error occured while reading the rule %s, flapCount must be positive", rule.Name)
+		return false
+	}
+	if rule.FlapCount == 0 {
+		return true
+	}
+	var err error
+	if rule.flapWithin, err = time.ParseDuration(rule.FlapWithin); err != nil || rule.flapWithin <= 0 {
+		log.Printf("An error occured while reading the rule %s, flapWithin must be a duration when flapCount is set", rule.Name)
+		return false
+	}
+	return true
+}
+
+func compileRuleRegexp(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+func matchesAny(values []string, candidates ...string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		for _, candidate := range candidates {
+			if value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matches returns true when every criteria of the rule matches the incident
+func (rule *Rule) matches(incident Incident) bool {
+	if !matchesAny(rule.ServiceIDs, incident.Service.ID) ||
+		!matchesAny(rule.Services, incident.Service.Summary) ||
+		!matchesAny(rule.EscalationPolicies, incident.EscalationPolicy.ID, incident.EscalationPolicy.Summary) ||
+		!matchesAny(rule.TriggerTypes, incident.FirstTriggerLogEntry.Channel.Type) {
+		return false
+	}
+	if rule.Urgency != "" && rule.Urgency != incident.Urgency {
+		return false
+	}
+	if rule.subjectRegexp != nil && !rule.subjectRegexp.MatchString(incident.Title) {
+		return false
+	}
+	if rule.incidentKeyRegexp != nil && !rule.incidentKeyRegexp.MatchString(incident.IncidentKey) {
+		return false
+	}
+	return true
+}
+
+// activeAt returns true when the rule applies at t: within one of its
+// windows, if any, and outside all of its excepted windows
+func (rule *Rule) activeAt(t time.Time) bool {
+	for _, window := range rule.exceptWindows {
+		if window.contains(t) {
+			return false
+		}
+	}
+	if len(rule.windows) == 0 {
+		return true
+	}
+	for _, window := range rule.windows {
+		if window.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRules returns the first rule of the target matching the incident and
+// whether the incident should be acted upon. Without any rule configured,
+// every incident is acted upon
+func (target *Target) matchRules(incident Incident) (rule *Rule, act bool) {
+	if len(target.Rules) == 0 {
+		return nil, true
+	}
+	for i := range target.Rules {
+		rule = &target.Rules[i]
+		if rule.activeAt(now()) && rule.matches(incident) && target.flapping(rule, incident) {
+			return rule, !rule.Exclude
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestIncident() Incident {
+	var incident Incident
+	incident.ID = "PO7FKW9"
+	incident.Title = "Disk usage on db01 is WARNING"
+	incident.Status = "triggered"
+	incident.Urgency = "low"
+	incident.IncidentKey = "66274f0746384df2ad51c04c2d4069bb"
+	incident.Service.ID = "P7C31P0"
+	incident.Service.Summary = "TEST_SERVICE"
+	incident.EscalationPolicy.ID = "P5W7JL2"
+	incident.EscalationPolicy.Summary = "MO - Sebastien Lariviere"
+	incident.FirstTriggerLogEntry.Channel.Type = "api"
+	return incident
+}
+
+func TestReadConfigFileRules(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	pwd, _ := os.Getwd()
+
+	_, res := readConfigFile(pwd + "/_example/rules.conf")
+	assert.Equal(t, res, true, "Expected _example/rules.conf to pass")
+	assert.Equal(t, len(config.Rules), 3, "Expected 3 rules in _example/rules.conf")
+	assert.Equal(t, config.Rules[0].Exclude, true, "The first rule should exclude the matching incidents")
+	assert.Equal(t, config.Rules[1].Name, "flapping-disk", "Invalid name for the second rule")
+	assert.Equal(t, config.Rules[1].snoozeDuration, 2*time.Hour, "Invalid snooze duration for the second rule")
+	assert.Equal(t, config.Rules[2].Name, "#3", "Rules without name should be named after their position")
+}
+
+func TestReadConfigFileInvalidRule(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	pwd, _ := os.Getwd()
+
+	b.Reset()
+	_, res := readConfigFile(pwd + "/_example/invalid_rule.conf")
+	traceBuffer.Flush()
+	assert.Equal(t, res, false, "Expected _example/invalid_rule.conf to fail")
+	for _, name := range []string{"broken", "snooze-forever", "reassign-nobody", "unknown-action"} {
+		assert.True(t, strings.Contains(b.String(), name), "Expected the rule %s in the error message: %s", name, b.String())
+	}
+}
+
+func TestMatchRulesWithoutRules(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	config.Rules = nil
+	target := newTestTarget()
+
+	rule, act := target.matchRules(newTestIncident())
+	assert.Nil(t, rule, "No rule should match when none is configured")
+	assert.Equal(t, act, true, "Every incident should be acted upon when no rule is configured")
+}
+
+func TestMatchRules(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	pwd, _ := os.Getwd()
+	readConfigFile(pwd + "/_example/rules.conf")
+	target := newTestTarget()
+
+	incident := newTestIncident()
+	rule, act := target.matchRules(incident)
+	assert.Equal(t, rule.Name, "flapping-disk", "Invalid rule matched")
+	assert.Equal(t, act, true, "The incident should be acted upon")
+
+	// First match wins, even if a later rule would include it
+	incident.Service.Summary = "DATABASE"
+	rule, act = target.matchRules(incident)
+	assert.Equal(t, rule.Name, "keep-database", "Invalid rule matched")
+	assert.Equal(t, act, false, "The incident should be excluded")
+
+	incident = newTestIncident()
+	incident.FirstTriggerLogEntry.Channel.Type = "web_trigger"
+	rule, act = target.matchRules(incident)
+	assert.Equal(t, rule.Name, "#3", "Invalid rule matched")
+	assert.Equal(t, act, true, "The incident should be acted upon")
+
+	incident.IncidentKey = "something-else"
+	rule, act = target.matchRules(incident)
+	assert.Nil(t, rule, "No rule should match")
+	assert.Equal(t, act, false, "Incidents not matching any rule should be left alone")
+}
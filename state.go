@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StatusTransition is a status an incident was seen in, and when
+type StatusTransition struct {
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+}
+
+// ActionRecord is an action pdack took on an incident, and its outcome
+type ActionRecord struct {
+	Action  string    `json:"action"`
+	Rule    string    `json:"rule,omitempty"`
+	At      time.Time `json:"at"`
+	Success bool      `json:"success"`
+}
+
+// IncidentState is everything pdack remembers about an incident
+type IncidentState struct {
+	ID          string             `json:"id"`
+	Number      int                `json:"number"`
+	Title       string             `json:"title"`
+	Target      string             `json:"target,omitempty"`
+	FirstSeen   time.Time          `json:"first_seen"`
+	LastSeen    time.Time          `json:"last_seen"`
+	Status      string             `json:"status"`
+	Transitions []StatusTransition `json:"transitions"`
+	Actions     []ActionRecord     `json:"actions,omitempty"`
+}
+
+// StateStore keeps the state of the incidents in a file, keyed by incident
+// ID, so it survives restarts
+type StateStore struct {
+	path      string
+	mutex     sync.Mutex
+	Incidents map[string]*IncidentState `json:"incidents"`
+}
+
+// stateRetention is how long an incident no longer seen is remembered
+var stateRetention = 30 * 24 * time.Hour
+
+// state is the store of the process, nil when no stateFile is configured
+var state *StateStore
+
+// loadState reads the state file, a missing file being an empty state
+func loadState(path string) (store *StateStore, err error) {
+	store = &StateStore{path: path, Incidents: map[string]*IncidentState{}}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, store); err != nil {
+		return nil, err
+	}
+	if store.Incidents == nil {
+		store.Incidents = map[string]*IncidentState{}
+	}
+	return store, nil
+}
+
+// observe records the incident has been seen by the target in its current
+// status
+func (store *StateStore) observe(target *Target, incident Incident) {
+	if store == nil {
+		return
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	seenAt := now()
+	incidentState, found := store.Incidents[incident.ID]
+	if !found {
+		incidentState = &IncidentState{ID: incident.ID, FirstSeen: seenAt}
+		store.Incidents[incident.ID] = incidentState
+	}
+	incidentState.Number = incident.IncidentNumber
+	incidentState.Title = incident.Title
+	incidentState.Target = target.Name
+	incidentState.LastSeen = seenAt
+	if incidentState.Status != incident.Status {
+		incidentState.Status = incident.Status
+		incidentState.Transitions = append(incidentState.Transitions, StatusTransition{Status: incident.Status, At: seenAt})
+	}
+}
+
+// recordAction records the action taken on the incident and its outcome
+func (store *StateStore) recordAction(incident Incident, rule *Rule, success bool) {
+	if store == nil {
+		return
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	incidentState, found := store.Incidents[incident.ID]
+	if !found {
+		return
+	}
+	record := ActionRecord{Action: ruleAction(rule), At: now(), Success: success}
+	if rule != nil {
+		record.Rule = rule.Name
+	}
+	incidentState.Actions = append(incidentState.Actions, record)
+}
+
+// save forgets the incidents not seen for stateRetention, then writes the
+// state to a temporary file renamed over the state file, so a crash never
+// leaves a partially written state behind
+func (store *StateStore) save() (err error) {
+	if store == nil {
+		return nil
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for id, incidentState := range store.Incidents {
+		if now().Sub(incidentState.LastSeen) > stateRetention {
+			delete(store.Incidents, id)
+		}
+	}
+	content, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temporary, err := ioutil.TempFile(filepath.Dir(store.path), filepath.Base(store.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+	if _, err := temporary.Write(content); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Sync(); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temporary.Name(), store.path); err != nil {
+		return err
+	}
+	// Make the rename itself durable
+	if directory, err := os.Open(filepath.Dir(store.path)); err == nil {
+		directory.Sync()
+		directory.Close()
+	}
+	return nil
+}
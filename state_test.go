@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/h2non/gock.v0"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateStore(t *testing.T) {
+	defer func() { now = time.Now }()
+	directory, _ := ioutil.TempDir("", "pdack")
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "state.json")
+
+	store, err := loadState(path)
+	assert.Nil(t, err, "A missing state file is an empty state")
+	assert.Equal(t, len(store.Incidents), 0, "A missing state file is an empty state")
+
+	target := newTestTarget()
+	incident := newTestIncident()
+	firstSeen := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return firstSeen }
+	store.observe(target, incident)
+	store.recordAction(incident, &Rule{Name: "flapping-disk", Action: actionSnooze}, true)
+
+	now = func() time.Time { return firstSeen.Add(time.Minute) }
+	incident.Status = "acknowledged"
+	store.observe(target, incident)
+	store.observe(target, incident)
+	assert.Nil(t, store.save(), "The state should be saved")
+
+	// Only the state file is left behind
+	files, _ := ioutil.ReadDir(directory)
+	assert.Equal(t, len(files), 1, "Temporary files should be renamed over the state file")
+
+	reloaded, err := loadState(path)
+	assert.Nil(t, err, "The state file should be read back")
+	incidentState := reloaded.Incidents[incident.ID]
+	assert.NotNil(t, incidentState, "The incident should be remembered")
+	assert.True(t, incidentState.FirstSeen.Equal(firstSeen), "Invalid first seen time")
+	assert.Equal(t, incidentState.Status, "acknowledged", "Invalid status")
+	assert.Equal(t, len(incidentState.Transitions), 2, "Expected a transition per status")
+	assert.Equal(t, incidentState.Transitions[0].Status, "triggered", "Invalid first transition")
+	assert.Equal(t, len(incidentState.Actions), 1, "Expected the snooze to be recorded")
+	assert.Equal(t, incidentState.Actions[0].Action, actionSnooze, "Invalid action recorded")
+	assert.Equal(t, incidentState.Actions[0].Rule, "flapping-disk", "Invalid rule recorded")
+	assert.Equal(t, incidentState.Actions[0].Success, true, "Invalid outcome recorded")
+
+	// Incidents not seen for a while are forgotten
+	now = func() time.Time { return firstSeen.Add(stateRetention + time.Hour) }
+	assert.Nil(t, reloaded.save(), "The state should be saved")
+	reloaded, _ = loadState(path)
+	assert.Equal(t, len(reloaded.Incidents), 0, "Old incidents should be forgotten")
+}
+
+func TestLoadStateCorrupted(t *testing.T) {
+	directory, _ := ioutil.TempDir("", "pdack")
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "state.json")
+	ioutil.WriteFile(path, []byte(`{"incidents": {`), 0600)
+
+	_, err := loadState(path)
+	assert.NotNil(t, err, "A corrupted state file should not be silently ignored")
+}
+
+func TestGetAssignedPDIncidentsWithState(t *testing.T) {
+	defer gock.Off()
+	defer func() { state = nil }()
+	directory, _ := ioutil.TempDir("", "pdack")
+	defer os.RemoveAll(directory)
+	state, _ = loadState(filepath.Join(directory, "state.json"))
+
+	target := newTestTarget()
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=" + config.UserID).
+		Reply(200).
+		BodyString(`{"incidents":[{"id":"PO7FKW9","type":"incident","incident_number":111661,"title":"t","status":"triggered"}],"limit":100,"offset":0,"total":null,"more":false}`)
+
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString("PO7FKW9").
+		Reply(200).
+		BodyString(`{"incidents":[]}`)
+
+	assert.Equal(t, target.getAssignedPDIncidents(), true, "Should send ack to the mentionned icident ID")
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+
+	reloaded, err := loadState(filepath.Join(directory, "state.json"))
+	assert.Nil(t, err, "The state should have been saved at the end of the refresh")
+	assert.Equal(t, reloaded.Incidents["PO7FKW9"].Number, 111661, "The incident should be remembered")
+	assert.Equal(t, reloaded.Incidents["PO7FKW9"].Actions[0].Action, actionAcknowledge, "The acknowledgement should be remembered")
+}
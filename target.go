@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/slariviere/pdack/pagerduty"
+)
+
+// Target is a PagerDuty user watched by pdack, with its own credentials,
+// rules and state
+type Target struct {
+	PagerDutyConfig
+	client      pagerduty.API
+	limiter     *RateLimiter
+	pdUserEmail string
+	idle        bool
+	occurrences map[string][]occurrence
+	// failedActions are the actions which failed by incident, tried again
+	// on a later poll
+	failedActions map[string]*failedAction
+	// fatal is the exit code of the unrecoverable error the target met, 0
+	// until then
+	fatal int
+	// auditing is the audit event of the action in progress, the requests
+	// sent to PagerDuty fill in their status and attempts
+	auditing *AuditEvent
+	// stopping is cancelled once the target is asked to stop, nil until it
+	// is polled
+	stopping context.Context
+	// mutex keeps the polls and the webhooks from handling the incidents of
+	// the target at the same time
+	mutex sync.Mutex
+}
+
+// TargetConfig contains the keys a [[target]] can define, the state file,
+// the audit log, the webhooks and the windows are only configured at the
+// top level
+type TargetConfig struct {
+	Name         string
+	APIKey       string
+	UserID       string
+	Account      string
+	RefreshDelay int
+	PageSize     int
+	MaxPages     int
+	Rules        []Rule `toml:"rule"`
+
+	APIKeyFile    string
+	APIKeyCommand string
+
+	ConnectTimeout int
+	RequestTimeout int
+	MaxRetries     int
+	RetryDelay     string
+	RetryMaxDelay  string
+
+	RequestsPerMinute int
+
+	ReconcileDelay int
+
+	// OnCallOnly is nil when the target does not define it, so a target can
+	// turn off the top level onCallOnly
+	OnCallOnly               *bool
+	OnCallSchedules          []string
+	OnCallEscalationPolicies []string
+}
+
+// newHTTPClient returns the HTTP client of the targets
+var newHTTPClient = pagerduty.NewHTTPClient
+
+func newTarget(targetConfig PagerDutyConfig) *Target {
+	target := &Target{
+		PagerDutyConfig: targetConfig,
+		occurrences:     map[string][]occurrence{},
+		failedActions:   map[string]*failedAction{},
+		limiter:         getRateLimiter(targetConfig.APIKey, targetConfig.getRequestsPerMinute()),
+	}
+	client := pagerduty.NewClient(targetConfig.APIKey)
+	client.HTTPClient = newHTTPClient(targetConfig.getConnectTimeout())
+	client.Observe = target.observe
+	target.client = client
+	return target
+}
+
+// observe records the status of the responses of PagerDuty in the audit
+// event of the action in progress, and whether the API key was rejected
+func (target *Target) observe(resp *http.Response) {
+	if target.auditing != nil {
+		target.auditing.HTTPStatus = resp.StatusCode
+	}
+	target.checkAPIKey(resp)
+}
+
+// getTargets returns the targets of the configuration, the configuration
+// itself being the only target when no [[target]] is defined. The targets
+// sharing an API key are allowed the lowest requestsPerMinute of them
+func getTargets() (targets []*Target) {
+	targetConfigs := config.targets
+	if len(targetConfigs) == 0 {
+		targetConfigs = []PagerDutyConfig{config}
+	}
+	lowest := map[string]int{}
+	for _, targetConfig := range targetConfigs {
+		if perMinute, found := lowest[targetConfig.APIKey]; !found || targetConfig.getRequestsPerMinute() < perMinute {
+			lowest[targetConfig.APIKey] = targetConfig.getRequestsPerMinute()
+		}
+	}
+	for _, targetConfig := range targetConfigs {
+		targetConfig.RequestsPerMinute = lowest[targetConfig.APIKey]
+		targets = append(targets, newTarget(targetConfig))
+	}
+	return targets
+}
+
+// readTargets fills in the values the targets do not define with the top
+// level ones, then validates them
+func readTargets() (success bool) {
+	success = true
+	config.targets = nil
+	for i, targetConfig := range config.Targets {
+		target := &PagerDutyConfig{
+			Name:                     targetConfig.Name,
+			APIKey:                   targetConfig.APIKey,
+			UserID:                   targetConfig.UserID,
+			RefreshDelay:             targetConfig.RefreshDelay,
+			PageSize:                 targetConfig.PageSize,
+			MaxPages:                 targetConfig.MaxPages,
+			Rules:                    targetConfig.Rules,
+			APIKeyFile:               targetConfig.APIKeyFile,
+			APIKeyCommand:            targetConfig.APIKeyCommand,
+			ConnectTimeout:           targetConfig.ConnectTimeout,
+			RequestTimeout:           targetConfig.RequestTimeout,
+			MaxRetries:               targetConfig.MaxRetries,
+			RetryDelay:               targetConfig.RetryDelay,
+			RetryMaxDelay:            targetConfig.RetryMaxDelay,
+			RequestsPerMinute:        targetConfig.RequestsPerMinute,
+			ReconcileDelay:           targetConfig.ReconcileDelay,
+			OnCallOnly:               config.OnCallOnly,
+			OnCallSchedules:          targetConfig.OnCallSchedules,
+			OnCallEscalationPolicies: targetConfig.OnCallEscalationPolicies,
+		}
+		if targetConfig.OnCallOnly != nil {
+			target.OnCallOnly = *targetConfig.OnCallOnly
+		}
+		if err := target.resolveAPIKey(); err != nil {
+			log.Printf("An error occured while reading the target #%d, %s", i+1, err)
+			success = false
+		}
+		if target.APIKey == "" {
+			target.APIKey = config.APIKey
+		}
+		if target.RefreshDelay == 0 {
+			target.RefreshDelay = config.RefreshDelay
+		}
+		if target.ReconcileDelay == 0 {
+			target.ReconcileDelay = config.ReconcileDelay
+		}
+		if target.ConnectTimeout == 0 {
+			target.ConnectTimeout = config.ConnectTimeout
+		}
+		if target.RequestTimeout == 0 {
+			target.RequestTimeout = config.RequestTimeout
+		}
+		if target.MaxRetries == 0 {
+			target.MaxRetries = config.MaxRetries
+		}
+		if target.RetryDelay == "" {
+			target.RetryDelay = config.RetryDelay
+		}
+		if target.RetryMaxDelay == "" {
+			target.RetryMaxDelay = config.RetryMaxDelay
+		}
+		if target.RequestsPerMinute == 0 {
+			target.RequestsPerMinute = config.RequestsPerMinute
+		}
+		if target.PageSize == 0 {
+			target.PageSize = config.PageSize
+		}
+		if target.MaxPages == 0 {
+			target.MaxPages = config.MaxPages
+		}
+		if len(target.Rules) == 0 {
+			target.Rules = config.Rules
+		}
+		if len(target.OnCallSchedules) == 0 {
+			target.OnCallSchedules = config.OnCallSchedules
+		}
+		if len(target.OnCallEscalationPolicies) == 0 {
+			target.OnCallEscalationPolicies = config.OnCallEscalationPolicies
+		}
+		if target.Name == "" {
+			target.Name = target.UserID
+		}
+		if target.Name == "" {
+			target.Name = "#" + strconv.Itoa(i+1)
+		}
+
+		for _, problem := range target.configProblems() {
+			log.Printf("An error occured while reading the target %s, %s", target.Name, problem)
+			success = false
+		}
+		// The top level rules the target inherits are already compiled
+		if len(targetConfig.Rules) > 0 && !compileRules(target.Rules) {
+			success = false
+		}
+		config.targets = append(config.targets, *target)
+	}
+	return success
+}
+
+// logf logs a message about the target, prefixed by its name when it has one
+func (target *Target) logf(format string, v ...interface{}) {
+	if target.Name != "" {
+		format = "[" + target.Name + "] " + format
+	}
+	log.Printf(format, v...)
+}
+
+// saveState writes the state of the incidents, if enabled
+func (target *Target) saveState() {
+	if err := state.save(); err != nil {
+		target.logf("An error occured while writing the state file: %s", err)
+	}
+}
+
+// poll acts on the incidents assigned to the target every RefreshDelay
+// seconds, or every ReconcileDelay seconds in webhook mode, until it meets
+// an unrecoverable error or stopping is cancelled. The request in flight
+// finishes, the rest of the poll is given up
+func (target *Target) poll(stopping context.Context) {
+	for {
+		success := target.getAssignedPDIncidents()
+		if target.getFatal() != 0 {
+			target.logf("Stopped watching the incidents of %s", target.UserID)
+			health.stopped(target)
+			return
+		}
+		if !success {
+			target.logf("The poll failed, trying again in %s", target.getPollDelay())
+		}
+		select {
+		case <-stopping.Done():
+			return
+		case <-time.After(target.getPollDelay()):
+		}
+	}
+}
+
+// stopRequested returns whether the target has been asked to stop
+func (target *Target) stopRequested() bool {
+	return target.stopping != nil && target.stopping.Err() != nil
+}
+
+// sleep waits for delay, returning false when the target is asked to stop
+// in the meantime
+func (target *Target) sleep(delay time.Duration) bool {
+	if target.stopping == nil {
+		time.Sleep(delay)
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-target.stopping.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// getFatal returns the exit code of the unrecoverable error the target met,
+// 0 when it can keep going
+func (target *Target) getFatal() int {
+	target.mutex.Lock()
+	defer target.mutex.Unlock()
+	return target.fatal
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/h2non/gock.v0"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadConfigFileTargets(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	pwd, _ := os.Getwd()
+
+	_, res := readConfigFile(pwd + "/_example/targets.conf")
+	assert.Equal(t, res, true, "Expected _example/targets.conf to pass")
+
+	targets := getTargets()
+	assert.Equal(t, len(targets), 2, "Expected 2 targets in _example/targets.conf")
+	assert.Equal(t, targets[0].Name, "alice", "Invalid name for the first target")
+	assert.Equal(t, targets[0].RefreshDelay, 60, "Targets should inherit the top level refreshDelay")
+	assert.Equal(t, targets[0].Rules[0].Name, "low-urgency", "Targets should inherit the top level rules")
+	assert.Equal(t, targets[1].Name, "PBOB001", "Targets without name should be named after their user")
+	assert.Equal(t, targets[1].APIKey, "456", "Invalid apiKey for the second target")
+	assert.Equal(t, targets[1].RefreshDelay, 30, "Targets should keep their own refreshDelay")
+	assert.Equal(t, len(targets[1].Rules), 1, "Targets should keep their own rules")
+	assert.Equal(t, targets[1].Rules[0].Name, "bob-everything", "Targets should keep their own rules")
+}
+
+func TestReadConfigFileTargetsOnCallOnly(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	path := filepath.Join(t.TempDir(), "pdack.conf")
+	writeTestConfig(t, path, "refreshDelay=60\nonCallOnly=true\n\n[[target]]\napiKey=\"123\"\nuserID=\"PALICE1\"\n\n[[target]]\napiKey=\"456\"\nuserID=\"PBOB001\"\nonCallOnly=false\n")
+
+	_, res := readConfigFile(path)
+	assert.Equal(t, res, true, "Expected the targets to pass")
+	targets := getTargets()
+	assert.True(t, targets[0].OnCallOnly, "Targets should inherit the top level onCallOnly")
+	assert.False(t, targets[1].OnCallOnly, "Targets should be able to turn off the top level onCallOnly")
+}
+
+func TestReadConfigFileInvalidTarget(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	pwd, _ := os.Getwd()
+
+	b.Reset()
+	_, res := readConfigFile(pwd + "/_example/invalid_target.conf")
+	traceBuffer.Flush()
+	assert.Equal(t, res, false, "Expected _example/invalid_target.conf to fail")
+	assert.True(t, strings.Contains(b.String(), "target alice, userID key is missing"), "Expected the missing key in the error message: %s", b.String())
+}
+
+func TestGetTargetsWithoutTargets(t *testing.T) {
+	targets := getTargets()
+	assert.Equal(t, len(targets), 1, "The configuration should be the only target")
+	assert.Equal(t, targets[0].UserID, config.UserID, "The configuration should be the only target")
+}
+
+// TestMainTargets tests every target is polled with its own credentials
+func TestMainTargets(t *testing.T) {
+	defer gock.Off()
+	os.Args = []string{os.Args[0], "--conf=" + privateCopy(t, "_example/targets.conf")}
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	testExitCode = 0
+
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=PALICE1").
+		MatchHeader("Authorization", "123").
+		Reply(401).
+		BodyString(`{"error":{"message":"Unauthorized","code":2006}}`)
+
+	gock.New("https://api.pagerduty.com/incidents?user_ids[]=PBOB001").
+		MatchHeader("Authorization", "456").
+		Reply(401).
+		BodyString(`{"error":{"message":"Unauthorized","code":2006}}`)
+
+	main()
+	assert.Equal(t, gock.IsDone(), true, "Did not sent the planned request to PD")
+	assert.Equal(t, testExitCode, exitAPIKeyRejected, "Program should have exited with code 3 once PagerDuty rejected the key of every target")
+}
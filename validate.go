@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// userIDPattern matches the IDs of the PagerDuty users, PXXXXXX
+var userIDPattern = regexp.MustCompile(`^[A-Z0-9]{7,}$`)
+
+// configProblems returns what is wrong with the keys of a target, or of the
+// configuration itself when it defines no target, one problem per line
+func (pdConfig PagerDutyConfig) configProblems() (problems []string) {
+	missing := map[string]bool{
+		"apiKey":       pdConfig.APIKey == "",
+		"userID":       pdConfig.UserID == "",
+		"refreshDelay": pdConfig.RefreshDelay == 0,
+	}
+	for _, key := range PagerDutyConfigKeys {
+		if missing[key] {
+			problems = append(problems, key+" key is missing")
+		}
+	}
+	if pdConfig.RefreshDelay < 0 {
+		problems = append(problems, "refreshDelay must be a positive number of seconds")
+	}
+	if pdConfig.ConnectTimeout < 0 || pdConfig.RequestTimeout < 0 {
+		problems = append(problems, "connectTimeout and requestTimeout must be positive numbers of seconds")
+	}
+	if pdConfig.RequestsPerMinute < 0 {
+		problems = append(problems, "requestsPerMinute must be a positive number")
+	}
+	if pdConfig.MaxRetries < 0 {
+		problems = append(problems, "maxRetries must be a positive number")
+	}
+	for _, delay := range []struct{ key, value string }{{"retryDelay", pdConfig.RetryDelay}, {"retryMaxDelay", pdConfig.RetryMaxDelay}} {
+		if _, err := time.ParseDuration(delay.value); delay.value != "" && err != nil {
+			problems = append(problems, fmt.Sprintf("%s %s is not a duration, like 500ms or 2s", delay.key, delay.value))
+		}
+	}
+	if pdConfig.UserID != "" && !userIDPattern.MatchString(pdConfig.UserID) {
+		problems = append(problems, fmt.Sprintf("userID %s is malformed, expected the ID found in the URL of the user profile, like PXXXXXX", pdConfig.UserID))
+	}
+	return problems
+}
+
+// warnAccount warns about the account keys of the configuration, only the
+// REST API v1 needed them
+func warnAccount() {
+	accounts := []string{config.Account}
+	for _, targetConfig := range config.Targets {
+		accounts = append(accounts, targetConfig.Account)
+	}
+	for _, account := range accounts {
+		if account != "" {
+			log.Printf("The account key is ignored, the REST API v2 only needs the API key")
+			return
+		}
+	}
+}
+
+// checkUndecoded reports the keys of the configuration file pdack does not
+// know about, typos most of the time
+func checkUndecoded(keys []string) (success bool) {
+	for _, key := range keys {
+		log.Printf("An error occured while reading the configuation file, unknown key %s", key)
+	}
+	return len(keys) == 0
+}
+
+// runCommand runs the subcommand given after the flags and returns the exit
+// code of pdack
+func runCommand(args []string) (exitCode int) {
+	if len(args) >= 2 && args[0] == "config" && args[1] == "check" {
+		// The flags may also follow the subcommand
+		if err := flag.CommandLine.Parse(args[2:]); err != nil {
+			return exitUsage
+		}
+		return runConfigCheck()
+	}
+	log.Printf("Unknown command %s, the only command is config check", strings.Join(args, " "))
+	return exitUsage
+}
+
+// runConfigCheck validates the configuration file without watching any
+// incident, for the CI
+func runConfigCheck() (exitCode int) {
+	path := getConfigFilePath()
+	if _, success := readConfigFile(path); !success || !checkMode() {
+		log.Printf("The configuration file %s is invalid", path)
+		return exitInvalidConfig
+	}
+	log.Printf("The configuration file %s is valid", path)
+	return exitOK
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigProblems(t *testing.T) {
+	valid := PagerDutyConfig{APIKey: "123", UserID: "PJGAQGT", RefreshDelay: 60}
+	assert.Empty(t, valid.configProblems(), "The configuration should be valid")
+
+	invalid := PagerDutyConfig{APIKey: "123", UserID: "pjgaqgt", RefreshDelay: -1, RequestTimeout: -5, RetryDelay: "1"}
+	assert.Equal(t, invalid.configProblems(), []string{
+		"refreshDelay must be a positive number of seconds",
+		"connectTimeout and requestTimeout must be positive numbers of seconds",
+		"retryDelay 1 is not a duration, like 500ms or 2s",
+		"userID pjgaqgt is malformed, expected the ID found in the URL of the user profile, like PXXXXXX",
+	}, "Every problem should be reported")
+
+	assert.Equal(t, PagerDutyConfig{}.configProblems(), []string{
+		"apiKey key is missing",
+		"userID key is missing",
+		"refreshDelay key is missing",
+	}, "Every missing key should be reported")
+}
+
+func TestReadConfigFileStrict(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	pwd, _ := os.Getwd()
+
+	traceBuffer.Flush()
+	b.Reset()
+	_, res := readConfigFile(pwd + "/_example/invalid_keys.conf")
+	traceBuffer.Flush()
+	assert.False(t, res, "The configuration should be invalid")
+	for _, expected := range []string{
+		"unknown key refreshdelai",
+		"unknown key rule.urgence",
+		"refreshDelay must be a positive number of seconds",
+		"userID sebastien@lariviere.me is malformed",
+	} {
+		assert.Contains(t, b.String(), expected, "Every problem should be logged")
+	}
+}
+
+func TestRunConfigCheck(t *testing.T) {
+	defer func(saved PagerDutyConfig, savedFilename string) {
+		config, *filename = saved, savedFilename
+	}(config, *filename)
+
+	assert.Equal(t, runCommand([]string{"config", "check", "-conf=" + privateCopy(t, "pdack_sample.conf")}), 0, "The sample configuration should be valid")
+	assert.Equal(t, runCommand([]string{"config", "check", "-conf=_example/invalid_keys.conf"}), 1, "An invalid configuration should fail the check")
+	assert.Equal(t, runCommand([]string{"config", "lint"}), 2, "Unknown commands should be rejected")
+}
+
+func TestRunConfigCheckTargetKeys(t *testing.T) {
+	defer func(saved PagerDutyConfig, savedFilename string) {
+		config, *filename = saved, savedFilename
+	}(config, *filename)
+	path := filepath.Join(t.TempDir(), "pdack.conf")
+	writeTestConfig(t, path, `refreshDelay=60
+
+[[rule]]
+name="broken"
+subject="^Disk (usage"
+
+[[target]]
+apiKey="123"
+userID="PALICE1"
+stateFile="/var/lib/pdack/alice.json"
+
+[[target.rule]]
+name="alice-low-urgency"
+urgency="low"
+
+[[target.window]]
+name="night"
+from="22:00"
+to="07:00"
+`)
+
+	traceBuffer.Flush()
+	b.Reset()
+	assert.Equal(t, runCommand([]string{"config", "check", "-conf=" + path}), 1, "The top level keys should be rejected in a target")
+	traceBuffer.Flush()
+	for _, expected := range []string{
+		"unknown key target.stateFile",
+		"unknown key target.window",
+		"rule broken, invalid subject",
+	} {
+		assert.Contains(t, b.String(), expected, "Every problem should be logged")
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Modes pdack runs in
+const (
+	modePoll    = "poll"
+	modeWebhook = "webhook"
+)
+
+var mode = flag.String("mode", modePoll, "How pdack learns about the incidents, poll or webhook")
+
+var defaultWebhookPath = "/webhook"
+var defaultReconcileDelay = 300
+
+// maxWebhookSize is the largest webhook payload accepted, in bytes
+var maxWebhookSize int64 = 1024 * 1024
+
+// WebhookEvent is the payload of a PagerDuty v3 webhook, only the fields
+// used to find the incidents to act upon are decoded
+type WebhookEvent struct {
+	Event struct {
+		ID           string `json:"id"`
+		EventType    string `json:"event_type"`
+		ResourceType string `json:"resource_type"`
+		OccurredAt   string `json:"occurred_at"`
+		Data         struct {
+			ID        string      `json:"id"`
+			Type      string      `json:"type"`
+			Status    string      `json:"status"`
+			Assignees []Reference `json:"assignees"`
+		} `json:"data"`
+	} `json:"event"`
+}
+
+// WebhookReceiver receives the PagerDuty v3 webhooks and acts on the
+// incidents they are about as the polls would
+type WebhookReceiver struct {
+	secret  string
+	targets []*Target
+	// mutex holds the new webhooks while the configuration is reloaded
+	mutex sync.Mutex
+	// pending are the webhooks being handled in the background
+	pending sync.WaitGroup
+}
+
+func newWebhookReceiver(secret string, targets []*Target) *WebhookReceiver {
+	return &WebhookReceiver{secret: secret, targets: targets}
+}
+
+func (pdConfig PagerDutyConfig) getWebhookPath() string {
+	if pdConfig.WebhookPath != "" {
+		return pdConfig.WebhookPath
+	}
+	return defaultWebhookPath
+}
+
+// getPollDelay returns how long the target waits between two polls. In
+// webhook mode the polls only reconcile the webhooks that were missed
+func (target *Target) getPollDelay() time.Duration {
+	if *mode != modeWebhook {
+		return time.Duration(target.RefreshDelay) * time.Second
+	}
+	if target.ReconcileDelay > 0 {
+		return time.Duration(target.ReconcileDelay) * time.Second
+	}
+	return time.Duration(defaultReconcileDelay) * time.Second
+}
+
+// checkMode validates the mode and the configuration it requires
+func checkMode() (success bool) {
+	switch *mode {
+	case modePoll:
+		return true
+	case modeWebhook:
+		if config.ListenAddress == "" || config.WebhookSecret == "" {
+			log.Printf("An error occured while reading the configuation file, listenAddress and webhookSecret are required in webhook mode")
+			return false
+		}
+		return true
+	}
+	log.Printf("Unknown mode %s, expected %s or %s", *mode, modePoll, modeWebhook)
+	return false
+}
+
+// verifySignature returns true when one of the signatures of the
+// X-PagerDuty-Signature header is the HMAC of the body with the secret.
+// There are several signatures while the secret is being rotated
+func (receiver *WebhookReceiver) verifySignature(body []byte, header string) bool {
+	mac := hmac.New(sha256.New, []byte(receiver.secret))
+	mac.Write(body)
+	expected := []byte("v1=" + hex.EncodeToString(mac.Sum(nil)))
+	for _, signature := range strings.Split(header, ",") {
+		if hmac.Equal([]byte(strings.TrimSpace(signature)), expected) {
+			return true
+		}
+	}
+	return false
+}
+
+func (receiver *WebhookReceiver) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(writer, req.Body, maxWebhookSize))
+	if err != nil {
+		http.Error(writer, "Unable to read the payload", http.StatusBadRequest)
+		return
+	}
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	if !receiver.verifySignature(body, req.Header.Get("X-PagerDuty-Signature")) {
+		log.Printf("A webhook has been rejected, invalid signature")
+		http.Error(writer, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	var webhook WebhookEvent
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		http.Error(writer, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	// PagerDuty expects a quick answer, the incident is handled in the
+	// background and the next reconciliation catches up on any failure
+	for _, target := range receiver.targets {
+		if webhook.concerns(target) {
+			receiver.pending.Add(1)
+			go func(target *Target) {
+				defer receiver.pending.Done()
+				target.handleWebhook(webhook)
+			}(target)
+		}
+	}
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// pause waits for the webhooks being handled and holds the new ones until
+// resume is called
+func (receiver *WebhookReceiver) pause() {
+	if receiver == nil {
+		return
+	}
+	receiver.mutex.Lock()
+	receiver.pending.Wait()
+}
+
+// resume hands the webhooks held since pause to the targets, verified with
+// the secret
+func (receiver *WebhookReceiver) resume(secret string, targets []*Target) {
+	if receiver == nil {
+		return
+	}
+	receiver.secret = secret
+	receiver.targets = targets
+	receiver.mutex.Unlock()
+}
+
+// concerns returns true when the webhook is about a triggered incident
+// assigned to the user of the target
+func (webhook WebhookEvent) concerns(target *Target) bool {
+	if webhook.Event.ResourceType != "incident" || webhook.Event.Data.Status != "triggered" {
+		return false
+	}
+	for _, assignee := range webhook.Event.Data.Assignees {
+		if assignee.ID == target.UserID {
+			return true
+		}
+	}
+	return false
+}
+
+// getPDIncident fetches an incident, the webhooks only carrying part of it
+func (target *Target) getPDIncident(id string) (incident Incident, success bool) {
+	success = target.request("get the incident "+id, func(ctx context.Context) (err error) {
+		incident, err = target.client.GetIncident(ctx, id)
+		return err
+	})
+	return incident, success
+}
+
+// handleWebhook acts on the incident of the webhook as a poll would
+func (target *Target) handleWebhook(webhook WebhookEvent) (success bool) {
+	target.mutex.Lock()
+	defer target.mutex.Unlock()
+
+	target.logf("Received %s about the incident %s", webhook.Event.EventType, webhook.Event.Data.ID)
+	if target.fatal != 0 {
+		target.logf("Ignored, the incidents of %s are no longer watched", target.UserID)
+		return false
+	}
+	active, success := target.checkOnCall()
+	if !success || !active {
+		return success
+	}
+	incident, success := target.getPDIncident(webhook.Event.Data.ID)
+	if !success {
+		return false
+	}
+	defer target.saveState()
+	target.recordOccurrence(incident)
+	state.observe(target, incident)
+	// The incident may have been acknowledged since the webhook was sent
+	if incident.Status != "triggered" {
+		return true
+	}
+	_, success = target.handleTriggeredIncident(incident)
+	return success
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/h2non/gock.v0"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testWebhook = `{"event":{"id":"01BZHUE6Z0ZO0NZB4VKZ9KWWQP","event_type":"incident.triggered","resource_type":"incident","occurred_at":"2026-10-17T09:00:00.000Z","data":{"id":"PO7FKW9","type":"incident","status":"triggered","assignees":[{"id":"XXXXXXX","type":"user_reference"}]}}}`
+
+func signWebhook(secret string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postWebhook(receiver *WebhookReceiver, body string, signature string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+	req.Header.Set("X-PagerDuty-Signature", signature)
+	recorder := httptest.NewRecorder()
+	receiver.ServeHTTP(recorder, req)
+	receiver.pending.Wait()
+	return recorder
+}
+
+func TestWebhookSignature(t *testing.T) {
+	receiver := newWebhookReceiver("secret", nil)
+	assert.True(t, receiver.verifySignature([]byte("{}"), signWebhook("secret", "{}")), "The signature should be valid")
+	assert.True(t, receiver.verifySignature([]byte("{}"), signWebhook("old", "{}")+", "+signWebhook("secret", "{}")), "Any of the signatures should be enough")
+	assert.False(t, receiver.verifySignature([]byte("{}"), signWebhook("other", "{}")), "A signature made with another secret should be invalid")
+	assert.False(t, receiver.verifySignature([]byte("{}"), ""), "A missing signature should be invalid")
+}
+
+func TestWebhookInvalidSignature(t *testing.T) {
+	defer gock.Off()
+	receiver := newWebhookReceiver("secret", []*Target{newTestTarget()})
+
+	recorder := postWebhook(receiver, testWebhook, signWebhook("other", testWebhook))
+	assert.Equal(t, recorder.Code, 401, "The webhook should be rejected")
+	assert.True(t, gock.IsDone(), "Nothing should be sent to PagerDuty")
+}
+
+func TestWebhookAcknowledge(t *testing.T) {
+	defer gock.Off()
+	receiver := newWebhookReceiver("secret", []*Target{newTestTarget()})
+	gock.New("https://api.pagerduty.com").
+		Get("/incidents/PO7FKW9").
+		MatchParam("include[]", "first_trigger_log_entries").
+		Reply(200).
+		BodyString(`{"incident":{"id":"PO7FKW9","type":"incident","incident_number":111661,"title":"t","status":"triggered","service":{"id":"P7C31P0","summary":"TEST_SERVICE"}}}`)
+	gock.New("https://api.pagerduty.com").
+		Put("/incidents").
+		BodyString(`PO7FKW9`).
+		Reply(200).
+		BodyString(`{"incidents":[]}`)
+
+	recorder := postWebhook(receiver, testWebhook, signWebhook("secret", testWebhook))
+	assert.Equal(t, recorder.Code, 202, "The webhook should be accepted")
+	assert.True(t, gock.IsDone(), "The incident should be acknowledged")
+}
+
+func TestWebhookAlreadyAcknowledged(t *testing.T) {
+	defer gock.Off()
+	receiver := newWebhookReceiver("secret", []*Target{newTestTarget()})
+	gock.New("https://api.pagerduty.com").
+		Get("/incidents/PO7FKW9").
+		Reply(200).
+		BodyString(`{"incident":{"id":"PO7FKW9","type":"incident","incident_number":111661,"title":"t","status":"acknowledged","service":{"id":"P7C31P0","summary":"TEST_SERVICE"}}}`)
+
+	recorder := postWebhook(receiver, testWebhook, signWebhook("secret", testWebhook))
+	assert.Equal(t, recorder.Code, 202, "The webhook should be accepted")
+	assert.True(t, gock.IsDone(), "The incident should be fetched")
+	assert.False(t, gock.HasUnmatchedRequest(), "An incident acknowledged since should be left alone")
+}
+
+func TestWebhookPostponed(t *testing.T) {
+	defer gock.Off()
+	target := newTestTarget()
+	target.requeue(newTestIncident(), false)
+	receiver := newWebhookReceiver("secret", []*Target{target})
+	gock.New("https://api.pagerduty.com").
+		Get("/incidents/PO7FKW9").
+		Reply(200).
+		BodyString(`{"incident":{"id":"PO7FKW9","type":"incident","incident_number":111661,"title":"t","status":"triggered","service":{"id":"P7C31P0","summary":"TEST_SERVICE"}}}`)
+
+	recorder := postWebhook(receiver, testWebhook, signWebhook("secret", testWebhook))
+	assert.Equal(t, recorder.Code, 202, "The webhook should be accepted")
+	assert.True(t, gock.IsDone(), "The incident should be fetched")
+	assert.False(t, gock.HasUnmatchedRequest(), "The failed action should wait to be tried again")
+}
+
+func TestWebhookNotConcerned(t *testing.T) {
+	target := newTestTarget()
+	var webhook WebhookEvent
+	webhook.Event.ResourceType = "incident"
+	webhook.Event.Data.Status = "triggered"
+	webhook.Event.Data.Assignees = []Reference{{ID: "YYYYYYY"}}
+	assert.False(t, webhook.concerns(target), "An incident assigned to someone else should be ignored")
+	webhook.Event.Data.Assignees = []Reference{{ID: target.UserID}}
+	assert.True(t, webhook.concerns(target), "An incident assigned to the user should be handled")
+	webhook.Event.Data.Status = "resolved"
+	assert.False(t, webhook.concerns(target), "A resolved incident should be ignored")
+}
+
+func TestCheckMode(t *testing.T) {
+	defer func() { *mode = modePoll }()
+	defer func(listenAddress, secret string) {
+		config.ListenAddress, config.WebhookSecret = listenAddress, secret
+	}(config.ListenAddress, config.WebhookSecret)
+
+	assert.True(t, checkMode(), "The poll mode needs nothing more")
+	*mode = modeWebhook
+	config.ListenAddress, config.WebhookSecret = ":0", ""
+	assert.False(t, checkMode(), "The webhook mode requires a secret")
+	config.WebhookSecret = "secret"
+	assert.True(t, checkMode(), "The webhook mode should be valid")
+	*mode = "push"
+	assert.False(t, checkMode(), "Unknown modes should be rejected")
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Window is a period of time during which rules apply, or do not apply.
+// Every criteria that is set must match, except for the dates and the
+// calendar events where any of them is enough. A window without any
+// criteria always matches
+type Window struct {
+	Name     string
+	Timezone string
+	From     string
+	To       string
+	Days     []string
+	Dates    []string
+	Calendar string
+
+	location *time.Location
+	from     time.Duration
+	to       time.Duration
+	events   []calendarEvent
+}
+
+// calendarEvent is an event read from an iCalendar file, end is exclusive
+type calendarEvent struct {
+	start time.Time
+	end   time.Time
+}
+
+var weekDays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// now returns the current time, replaced during the tests
+var now = time.Now
+
+// compileWindows validates the windows of the configuration and loads their
+// calendars
+func compileWindows() (success bool) {
+	success = true
+	names := map[string]bool{}
+	for i := range config.Windows {
+		window := &config.Windows[i]
+		if window.Name == "" || names[window.Name] {
+			log.Printf("An error occured while reading the window #%d, a unique name is required", i+1)
+			success = false
+		}
+		names[window.Name] = true
+		if !window.compile() {
+			success = false
+		}
+	}
+	return success
+}
+
+func (window *Window) compile() (success bool) {
+	var err error
+	// time.LoadLocation returns UTC without a timezone, the local one is
+	// the default
+	window.location = time.Local
+	if window.Timezone != "" {
+		if window.location, err = time.LoadLocation(window.Timezone); err != nil {
+			log.Printf("An error occured while reading the window %s, invalid timezone: %s", window.Name, err)
+			return false
+		}
+	}
+	if (window.From == "") != (window.To == "") {
+		log.Printf("An error occured while reading the window %s, from and to go together", window.Name)
+		return false
+	}
+	if window.From != "" {
+		from, errFrom := time.Parse("15:04", window.From)
+		to, errTo := time.Parse("15:04", window.To)
+		if errFrom != nil || errTo != nil {
+			log.Printf("An error occured while reading the window %s, from and to must be formatted as 15:04", window.Name)
+			return false
+		}
+		window.from = time.Duration(from.Hour())*time.Hour + time.Duration(from.Minute())*time.Minute
+		window.to = time.Duration(to.Hour())*time.Hour + time.Duration(to.Minute())*time.Minute
+	}
+	for _, day := range window.Days {
+		if _, ok := weekDays[strings.ToLower(day)]; !ok {
+			log.Printf("An error occured while reading the window %s, invalid day %s", window.Name, day)
+			return false
+		}
+	}
+	for _, date := range window.Dates {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			log.Printf("An error occured while reading the window %s, invalid date %s", window.Name, date)
+			return false
+		}
+	}
+	if window.Calendar != "" {
+		if window.events, err = readCalendar(window.Calendar, window.location); err != nil {
+			log.Printf("An error occured while reading the calendar of the window %s: %s", window.Name, err)
+			return false
+		}
+	}
+	return true
+}
+
+// contains returns true when t is within the window
+func (window *Window) contains(t time.Time) bool {
+	local := t.In(window.location)
+	if len(window.Days) > 0 {
+		found := false
+		for _, day := range window.Days {
+			found = found || weekDays[strings.ToLower(day)] == local.Weekday()
+		}
+		if !found {
+			return false
+		}
+	}
+	if (len(window.Dates) > 0 || window.Calendar != "") && !window.onDateOrEvent(t) {
+		return false
+	}
+	if window.From != "" {
+		// The wall clock time, days switching to or from daylight saving
+		// time not lasting 24 hours
+		sinceMidnight := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+		if window.from <= window.to && (sinceMidnight < window.from || sinceMidnight >= window.to) {
+			return false
+		}
+		// The window wraps around midnight, 22:00 to 07:00 for instance
+		if window.from > window.to && sinceMidnight < window.from && sinceMidnight >= window.to {
+			return false
+		}
+	}
+	return true
+}
+
+// onDateOrEvent returns true when t is on one of the dates of the window or
+// during one of the events of its calendar
+func (window *Window) onDateOrEvent(t time.Time) bool {
+	if len(window.Dates) > 0 && matchesAny(window.Dates, t.In(window.location).Format("2006-01-02")) {
+		return true
+	}
+	for _, event := range window.events {
+		if !t.Before(event.start) && t.Before(event.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// readCalendar reads the events of an iCalendar file. Recurring events are
+// not supported, only their first occurrence is read
+func readCalendar(calendarFileName string, location *time.Location) (events []calendarEvent, err error) {
+	file, err := os.Open(calendarFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Long lines are folded, continuation lines start with a space or a tab
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+		} else {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var event *calendarEvent
+	allDay := false
+	for _, line := range lines {
+		separator := strings.Index(line, ":")
+		if separator < 0 {
+			continue
+		}
+		name, params, value := line[:separator], "", line[separator+1:]
+		if semicolon := strings.Index(name, ";"); semicolon >= 0 {
+			name, params = name[:semicolon], name[semicolon+1:]
+		}
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			event = &calendarEvent{}
+		case name == "END" && value == "VEVENT" && event != nil:
+			if event.end.IsZero() && allDay {
+				event.end = event.start.AddDate(0, 0, 1)
+			}
+			events = append(events, *event)
+			event = nil
+		case name == "DTSTART" && event != nil:
+			if event.start, allDay, err = parseCalendarTime(params, value, location); err != nil {
+				return nil, err
+			}
+		case name == "DTEND" && event != nil:
+			if event.end, _, err = parseCalendarTime(params, value, location); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return events, nil
+}
+
+// parseCalendarTime parses an iCalendar date or date-time, in UTC when it ends
+// with Z, in its TZID parameter when set and in location otherwise
+func parseCalendarTime(params string, value string, location *time.Location) (t time.Time, allDay bool, err error) {
+	for _, param := range strings.Split(params, ";") {
+		if strings.HasPrefix(param, "TZID=") {
+			if tzLocation, err := time.LoadLocation(strings.Trim(param[5:], `"`)); err == nil {
+				location = tzLocation
+			}
+		}
+	}
+	if len(value) == 8 {
+		t, err = time.ParseInLocation("20060102", value, location)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	t, err = time.ParseInLocation("20060102T150405", value, location)
+	return t, false, err
+}
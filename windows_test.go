@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func montreal(value string) time.Time {
+	location, _ := time.LoadLocation("America/Montreal")
+	t, _ := time.ParseInLocation("2006-01-02 15:04", value, location)
+	return t
+}
+
+func TestWindowContains(t *testing.T) {
+	night := Window{Name: "night", Timezone: "America/Montreal", From: "22:00", To: "07:00"}
+	assert.Equal(t, night.compile(), true, "Expected the night window to compile")
+	assert.Equal(t, night.contains(montreal("2026-10-16 23:30")), true, "23:30 is at night")
+	assert.Equal(t, night.contains(montreal("2026-10-17 06:59")), true, "06:59 is at night")
+	assert.Equal(t, night.contains(montreal("2026-10-17 07:00")), false, "07:00 is not at night anymore")
+	assert.Equal(t, night.contains(montreal("2026-10-17 12:00")), false, "12:00 is not at night")
+	// 03:30 UTC is 23:30 the day before in Montreal
+	assert.Equal(t, night.contains(time.Date(2026, 10, 17, 3, 30, 0, 0, time.UTC)), true, "Times should be compared in the window timezone")
+
+	// Montreal switches to daylight saving time on 2026-03-08 at 02:00 and
+	// back on 2026-11-01 at 02:00
+	assert.Equal(t, night.contains(montreal("2026-03-08 22:30")), true, "22:30 is at night when switching to daylight saving time")
+	assert.Equal(t, night.contains(montreal("2026-03-08 21:30")), false, "21:30 is not at night when switching to daylight saving time")
+	assert.Equal(t, night.contains(montreal("2026-11-01 21:30")), false, "21:30 is not at night when switching back from daylight saving time")
+	assert.Equal(t, night.contains(montreal("2026-11-01 06:30")), true, "06:30 is at night when switching back from daylight saving time")
+
+	business := Window{Name: "business", Timezone: "America/Montreal", From: "09:00", To: "17:00", Days: []string{"mon", "tue", "wed", "thu", "fri"}}
+	assert.Equal(t, business.compile(), true, "Expected the business window to compile")
+	assert.Equal(t, business.contains(montreal("2026-10-16 10:00")), true, "Friday 10:00 is during business hours")
+	assert.Equal(t, business.contains(montreal("2026-10-17 10:00")), false, "Saturday is not a business day")
+
+	dates := Window{Name: "dates", Dates: []string{"2026-07-01"}}
+	assert.Equal(t, dates.compile(), true, "Expected the dates window to compile")
+	assert.Equal(t, dates.location, time.Local, "The timezone should default to the local one")
+	assert.Equal(t, dates.contains(time.Date(2026, 7, 1, 12, 0, 0, 0, time.Local)), true, "The whole date is in the window")
+	assert.Equal(t, dates.contains(time.Date(2026, 7, 2, 12, 0, 0, 0, time.Local)), false, "The next date is not in the window")
+}
+
+func TestWindowCalendar(t *testing.T) {
+	pwd, _ := os.Getwd()
+	holidays := Window{Name: "holidays", Timezone: "America/Montreal", Calendar: pwd + "/_example/holidays.ics"}
+	assert.Equal(t, holidays.compile(), true, "Expected the holidays window to compile")
+	assert.Equal(t, len(holidays.events), 2, "Expected 2 events in _example/holidays.ics")
+	assert.Equal(t, holidays.contains(montreal("2026-12-25 00:00")), true, "All day events start at midnight")
+	assert.Equal(t, holidays.contains(montreal("2026-12-25 23:59")), true, "All day events last the whole day")
+	assert.Equal(t, holidays.contains(montreal("2026-12-26 00:00")), false, "All day events end at midnight")
+	assert.Equal(t, holidays.contains(montreal("2026-10-15 12:00")), true, "During the offsite")
+	assert.Equal(t, holidays.contains(montreal("2026-10-15 17:00")), false, "After the offsite")
+}
+
+func TestInvalidWindows(t *testing.T) {
+	windows := []Window{
+		{Name: "timezone", Timezone: "Mars/Olympus_Mons"},
+		{Name: "half", From: "22:00"},
+		{Name: "format", From: "10pm", To: "7am"},
+		{Name: "day", Days: []string{"someday"}},
+		{Name: "date", Dates: []string{"2026-13-01"}},
+		{Name: "calendar", Calendar: "_example/does_not_exists.ics"},
+	}
+	for _, window := range windows {
+		assert.Equal(t, window.compile(), false, "Expected the window %s to fail", window.Name)
+	}
+}
+
+func TestReadConfigFileWindows(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	defer func() { now = time.Now }()
+	pwd, _ := os.Getwd()
+
+	_, res := readConfigFile(pwd + "/_example/windows.conf")
+	assert.Equal(t, res, true, "Expected _example/windows.conf to pass")
+
+	target := newTestTarget()
+	incident := newTestIncident()
+
+	now = func() time.Time { return montreal("2026-10-16 23:30") }
+	rule, act := target.matchRules(incident)
+	assert.Equal(t, rule.Name, "low-urgency-at-night", "The rule applies at night")
+	assert.Equal(t, act, true, "The incident should be acted upon at night")
+
+	now = func() time.Time { return montreal("2026-10-16 12:00") }
+	rule, act = target.matchRules(incident)
+	assert.Nil(t, rule, "The rule does not apply during the day")
+	assert.Equal(t, act, false, "The incident should be left alone during the day")
+
+	now = func() time.Time { return montreal("2026-12-25 01:00") }
+	rule, act = target.matchRules(incident)
+	assert.Nil(t, rule, "The rule does not apply during holidays")
+	assert.Equal(t, act, false, "The incident should be left alone during holidays")
+}
+
+func TestReadConfigFileUnknownWindow(t *testing.T) {
+	defer func(saved PagerDutyConfig) { config = saved }(config)
+	config.Windows = nil
+	config.Rules = []Rule{{Name: "nowhere", Windows: []string{"never-defined"}}}
+
+	b.Reset()
+	res := compileRules(config.Rules)
+	traceBuffer.Flush()
+	assert.Equal(t, res, false, "Rules referencing unknown windows should fail")
+	assert.True(t, strings.Contains(b.String(), "unknown window never-defined"), "Expected the unknown window in the error message: %s", b.String())
+}